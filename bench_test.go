@@ -0,0 +1,197 @@
+package web
+
+import (
+	"math/rand"
+	"testing"
+)
+
+/* Benchmarks that replay editing traces against every Buffer backend, with
+   a plain []byte as the baseline. Every trace is generated from a fixed seed
+   so runs can be compared.
+ */
+
+// a plain slice, everything after an edit gets moved
+type sliceBuffer struct {
+	buf []byte
+}
+
+func (s *sliceBuffer)Len() int {
+	return len(s.buf)
+}
+
+func (s *sliceBuffer)ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(s.buf)) {
+		return read_result(0, len(p))
+	}
+	return read_result(copy(p, s.buf[off:]), len(p))
+}
+
+func (s *sliceBuffer)Insert(off int, p []byte) error {
+	s.buf = append(s.buf[:off], append(p, s.buf[off:]...)...)
+	return nil
+}
+
+func (s *sliceBuffer)Delete(off, n int) error {
+	s.buf = append(s.buf[:off], s.buf[off+n:]...)
+	return nil
+}
+
+func (s *sliceBuffer)Snapshot() Buffer {
+	return &sliceBuffer{append([]byte(nil), s.buf...)}
+}
+
+var backends = []struct{
+	name string
+	new  func(doc []byte) Buffer
+}{
+	{"slice", func(doc []byte) Buffer {
+		return &sliceBuffer{append([]byte(nil), doc...)}
+	}},
+	{"gap", func(doc []byte) Buffer { return NewGapBuffer(doc) }},
+	{"piece", func(doc []byte) Buffer { return NewPieceTable(doc) }},
+	{"trie", func(doc []byte) Buffer { return NewTrieBuffer(doc) }},
+}
+
+// one step of a trace. positions are picked when the trace is made, as a
+// fraction of the document so they stay in range as it grows and shrinks
+type edit struct {
+	del  bool
+	at   float64
+	n    int
+	text []byte
+}
+
+func (e edit)apply(buf Buffer) {
+	size := buf.Len()
+	i := int(e.at * float64(size))
+	if e.del {
+		n := min(e.n, size-i)
+		if n > 0 {
+			buf.Delete(i, n)
+		}
+		return
+	}
+	buf.Insert(i, e.text)
+}
+
+const trace_len = 4096
+
+// typing: a cursor that mostly moves forward one key at a time, with the
+// odd backspace and the odd jump somewhere else
+func typingTrace(r *rand.Rand) []edit {
+	trace := make([]edit, trace_len)
+	at := 0.5
+	for i := range trace {
+		if r.Intn(200) == 0 {
+			at = r.Float64()
+		}
+		if r.Intn(10) == 0 {
+			trace[i] = edit{del: true, at: at, n: 1}
+		} else {
+			trace[i] = edit{at: at, text: []byte{letters[r.Intn(len(letters))]}}
+			at += 1e-6
+		}
+	}
+	return trace
+}
+
+// inserts only, so the document grows by up to 16*trace_len over the trace
+func randomInsertTrace(r *rand.Rand) []edit {
+	trace := make([]edit, trace_len)
+	for i := range trace {
+		trace[i] = edit{at: r.Float64(), text: randSeq(1+r.Intn(16))}
+	}
+	return trace
+}
+
+// deletes are paired with inserts of the same length so the document keeps
+// its size over a long run
+func randomDeleteTrace(r *rand.Rand) []edit {
+	trace := make([]edit, trace_len)
+	for i := range trace {
+		n := 1+r.Intn(64)
+		if i%2 == 0 {
+			trace[i] = edit{del: true, at: r.Float64(), n: n}
+		} else {
+			trace[i] = edit{at: r.Float64(), text: randSeq(trace[i-1].n)}
+		}
+	}
+	return trace
+}
+
+// pasting large blocks, which is where the trie gets to concat
+func pasteTrace(r *rand.Rand) []edit {
+	trace := make([]edit, trace_len)
+	for i := range trace {
+		if i%2 == 0 {
+			trace[i] = edit{at: r.Float64(), text: randSeq(1024+r.Intn(8192))}
+		} else {
+			trace[i] = edit{del: true, at: r.Float64(), n: len(trace[i-1].text)}
+		}
+	}
+	return trace
+}
+
+const doc_size = 1<<18
+
+func benchTrace(b *testing.B, mk func(r *rand.Rand) []edit) {
+	trace := mk(rand.New(rand.NewSource(1)))
+	doc := randSeq(doc_size)
+	for _, e := range backends {
+		b.Run(e.name, func(b *testing.B) {
+			buf := e.new(doc)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// every pass over the trace starts from doc again, so
+				// the document is never bigger for a bigger b.N
+				if i > 0 && i%len(trace) == 0 {
+					b.StopTimer()
+					buf = e.new(doc)
+					b.StartTimer()
+				}
+				trace[i%len(trace)].apply(buf)
+			}
+		})
+	}
+}
+
+func BenchmarkEditTyping(b *testing.B) {
+	benchTrace(b, typingTrace)
+}
+
+func BenchmarkEditRandomInsert(b *testing.B) {
+	benchTrace(b, randomInsertTrace)
+}
+
+func BenchmarkEditRandomDelete(b *testing.B) {
+	benchTrace(b, randomDeleteTrace)
+}
+
+func BenchmarkEditPaste(b *testing.B) {
+	benchTrace(b, pasteTrace)
+}
+
+// scrolling through a document that has already been edited a lot, a screen
+// at a time
+func BenchmarkEditScroll(b *testing.B) {
+	trace := randomInsertTrace(rand.New(rand.NewSource(1)))
+	doc := randSeq(doc_size)
+	screen := make([]byte, 80*50)
+	for _, e := range backends {
+		b.Run(e.name, func(b *testing.B) {
+			buf := e.new(doc)
+			for _, t := range trace {
+				t.apply(buf)
+			}
+			b.ResetTimer()
+			at := 0
+			for i := 0; i < b.N; i++ {
+				if at+len(screen) > buf.Len() {
+					at = 0
+				}
+				buf.ReadAt(screen, int64(at))
+				at += len(screen)
+			}
+		})
+	}
+}
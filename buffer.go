@@ -224,7 +224,17 @@ func (t *Trie[T])ReadSlice(index int) ([]T,error) {
 	}
 }
 
+// Take keeps the first index elements.
 func (t *Trie[T])Take(id, index int) *Trie[T] {
+	if index == 0 {
+		return NewTrans[T](0, id)
+	}
+	return t.take(id, index).shrink()
+}
+
+// take is Take that keeps the height of t, so the trie it returns can go
+// where t was. index is never 0 below the root.
+func (t *Trie[T])take(id, index int) *Trie[T] {
 	if t.height == 0 {
 		assert(index < m)
 		n := t.CloneTrans(id)
@@ -241,15 +251,27 @@ func (t *Trie[T])Take(id, index int) *Trie[T] {
 	}
 	assert(i < t.length)
 
-	if i == 0 {
-		return t.subtrie[0].Take(id, index)
-	} else {
-		n := t.CloneTrans(id)
-		n.subtrie[i] = n.subtrie[i].Take(id,index)
-		n.subsize[i] = n.subsize[i-1] + n.subtrie[i].Size()
-		n.length = i+1
+	n := t.CloneTrans(id)
+	if index == 0 {
+		// the subtrie at i goes as a whole
+		n.length = i
 		return n
 	}
+	n.subtrie[i] = n.subtrie[i].take(id,index)
+	n.subsize[i] = n.subtrie[i].Size()
+	if i > 0 {
+		n.subsize[i] += n.subsize[i-1]
+	}
+	n.length = i+1
+	return n
+}
+
+// shrink takes off the roots with a single subtrie
+func (t *Trie[T])shrink() *Trie[T] {
+	for t.height > 0 && t.length == 1 {
+		t = t.subtrie[0]
+	}
+	return t
 }
 
 func drop_array[T any](arr *[m]T, len_arr, len_new_arr int) *[m]T{
@@ -271,7 +293,13 @@ func subsize[T any](subtrie *[m]*Trie[T], length int) *[m]int {
 	return &new_subsize
 }
 
+// Drop leaves out the first index elements.
 func (t *Trie[T])Drop(id,index int) *Trie[T] {
+	return t.drop(id, index).shrink()
+}
+
+// drop is Drop that keeps the height of t
+func (t *Trie[T])drop(id,index int) *Trie[T] {
 	if t.height == 0 {
 		assert(index < t.length)
 		n := t.CloneTrans(id)
@@ -289,39 +317,159 @@ func (t *Trie[T])Drop(id,index int) *Trie[T] {
 	}
 	assert(i < t.length)
 
-	if i == t.length-1 {
-		return t.subtrie[i].Drop(id, index)
-	} else {
-		n := t.CloneTrans(id)
-		n.subtrie = drop_array[*Trie[T]](n.subtrie, n.length, n.length-i)
-		n.length = n.length-i
-		n.subtrie[0] = n.subtrie[0].Drop(id, index)
-		n.subsize = subsize[T](n.subtrie, n.length)
-		return n
+	n := t.CloneTrans(id)
+	n.subtrie = drop_array[*Trie[T]](n.subtrie, n.length, n.length-i)
+	n.length = n.length-i
+	if index > 0 {
+		n.subtrie[0] = n.subtrie[0].drop(id, index)
+	}
+	n.subsize = subsize[T](n.subtrie, n.length)
+	return n
+}
+
+// Insert puts vs in front of the element at index, index == Size() appends.
+// Take and Drop want their index to be inside the trie, so the ends are
+// handled here.
+func (t *Trie[T])Insert(id, index int, vs []T) *Trie[T] {
+	assert(index >= 0 && index <= t.Size())
+	if len(vs) == 0 {
+		return t
+	}
+	n := TrieFromSlice[T](vs)
+	if index > 0 {
+		if index < t.Size() {
+			n = t.Take(id, index).Concat(n)
+		} else {
+			n = t.Concat(n)
+		}
+	}
+	if index < t.Size() {
+		n = n.Concat(t.Drop(id, index))
+	}
+	return n
+}
+
+// Delete removes count elements starting at index
+func (t *Trie[T])Delete(id, index, count int) *Trie[T] {
+	end := index + count
+	assert(index >= 0 && count >= 0 && end <= t.Size())
+	switch {
+	case count == 0:
+		return t
+	case index == 0 && end == t.Size():
+		return NewTrans[T](0, id)
+	case index == 0:
+		return t.Drop(id, end)
+	case end == t.Size():
+		return t.Take(id, index)
+	}
+	return t.Take(id, index).Concat(t.Drop(id, end))
+}
+
+// ReadInto copies the elements starting at index into p, and returns how many
+// it copied. Unlike ReadSlice it never hands out the underlying arrays.
+func (t *Trie[T])ReadInto(index int, p []T) int {
+	if index >= t.Size() || len(p) == 0 {
+		return 0
+	}
+	if t.height == 0 {
+		return copy(p, t.content[index:t.length])
+	}
+	i := index>>(b*t.height)
+	for (index >= t.subsize[i]){
+		i++
 	}
+	c := 0
+	for ; i < t.length && c < len(p); i++ {
+		subtrie_starts := 0
+		if i > 0 {
+			subtrie_starts = t.subsize[i-1]
+		}
+		from := 0
+		if index > subtrie_starts {
+			from = index-subtrie_starts
+		}
+		c += t.subtrie[i].ReadInto(from, p[c:])
+	}
+	return c
 }
 
 // a stateful iterator to scroll through the Trie
 type Iterator[T any] struct {
 	stack []*Trie[T] // sorted by height, stack[0] contains the trie with height 0
-	point int        // that contains the index
+	point int        // that contains the index, and point is where it is in there
+	slots []int      // slots[h] is where stack[h] is in stack[h+1]
 }
 
+// Iterator starts at starting_index. It is done right away when the index
+// is past the end.
 func (t *Trie[T])Iterator(starting_index int) *Iterator[T] {
-	stack := make([]*Trie[T], t.height)
-	i := &Iterator{}
+	i := &Iterator[T]{
+		stack: make([]*Trie[T], t.height+1),
+		slots: make([]int, t.height),
+	}
+	if starting_index < 0 || starting_index >= t.Size() {
+		return i
+	}
+	index := starting_index
+	for h := t.height; h > 0; h-- {
+		i.stack[h] = t
+		s := index>>(b*h)
+		for (index >= t.subsize[s]){
+			s++
+		}
+		if s > 0 {
+			index -= t.subsize[s-1]
+		}
+		i.slots[h-1] = s
+		t = t.subtrie[s]
+	}
+	i.stack[0] = t
+	i.point = index
+	return i
+}
+
+// Done tells if the iterator went past the last element.
+func (i *Iterator[T])Done() bool {
+	return i.stack[0] == nil
 }
 
+// Next moves on to the next element.
 func (i *Iterator[T])Next() {
+	i.point++
+	for !i.Done() && i.point >= i.stack[0].length {
+		i.NextTrie(0)
+	}
 }
 
+// NextTrie moves on to the first element of the trie after the one at
+// height that holds the current element.
 func (i *Iterator[T])NextTrie(height int){
+	h := height
+	for h+1 < len(i.stack) && i.slots[h]+1 >= i.stack[h+1].length {
+		h++
+	}
+	if h+1 >= len(i.stack) {
+		i.stack[0] = nil
+		return
+	}
+	i.slots[h]++
+	for ; h >= 0; h-- {
+		i.stack[h] = i.stack[h+1].subtrie[i.slots[h]]
+		if h > 0 {
+			i.slots[h-1] = 0
+		}
+	}
+	i.point = 0
 }
 
 func (i *Iterator[T])Content() T {
+	return i.stack[0].content[i.point]
 }
 
+// Trie returns the trie with height 0 the current element is in.
 func (i *Iterator[T])Trie() *Trie[T] {
+	return i.stack[0]
 }
 
 // Concat has got to be the most difficult algorithm I've ever imagined.
@@ -338,10 +486,10 @@ var strategy [2*m*m]struct{
 
 func init() {
 	for i:=0; i<m-1; i++ {
-		strategy[i] = struct{0,0,i}
+		strategy[i].lf = i
 	}
-	strategy[m-1] = struct{0,1,0}
-	strategy[m] = struct{1,0,0}
+	strategy[m-1].mo = 1
+	strategy[m].ms = 1
 	for i:=m+1; i<2*m*m; i++ {
 		plan_ms := strategy[i-m]
 		plan_mo := strategy[i-m+1]
@@ -352,25 +500,139 @@ func init() {
 			strategy[i] = plan_mo
 			strategy[i].mo++
 		} else {
-			strategy[i] = strategy[i]
+			strategy[i] = strategy[i-1]
 			strategy[i].lf++
 		}
 	}
 }
 
-func reshuffled_contents[T](l,r *Trie[T]) []*Trie[T]{
+// a pair of tries with height 1 may hold this many leaves more than the
+// strategy plans for before concat reshuffles them, so most concats move
+// no elements around at all
+const extra = 2
+
+// reshuffled_contents returns the leaves of l followed by the ones of r.
+// The two leaves at the seam become one when they fit, and once there are
+// more leaves than extra over the plan, the elements from the first leaf
+// that is not full on are moved into the leaves strategy plans for them.
+func reshuffled_contents[T any](l,r *Trie[T]) []*Trie[T]{
 	assert(l.height == 1)
 	assert(r.height == 1)
-	ms := strategy[l.Size() + r.Size()].ms
-	mo := strategy[l.Size() + r.Size()].mo
-	lf := strategy[l.Size() + r.Size()].lf
-	new_tries_len = ms + mo
-	if lf > 0 {
+	leaves := make([]*Trie[T], 0, l.length+r.length)
+	leaves = append(leaves, l.subtrie[:l.length]...)
+	last, first := l.subtrie[l.length-1], r.subtrie[0]
+	if last.length + first.length <= m {
+		seam := NewTrie[T](0)
+		seam.length = copy(seam.content[:], last.content[:last.length])
+		seam.length += copy(seam.content[seam.length:], first.content[:first.length])
+		leaves[len(leaves)-1] = seam
+		leaves = append(leaves, r.subtrie[1:r.length]...)
+	} else {
+		leaves = append(leaves, r.subtrie[:r.length]...)
+	}
+
+	full := 0
+	for full < len(leaves) && leaves[full].length == m {
+		full++
+	}
+	rest := 0
+	for _, leaf := range leaves[full:] {
+		rest += leaf.length
+	}
+	if rest >= len(strategy) {
+		return leaves
+	}
+	plan := strategy[rest]
+	new_tries_len := plan.ms + plan.mo
+	if plan.lf > 0 {
 		new_tries_len++
 	}
+	if len(leaves)-full <= new_tries_len+extra {
+		return leaves
+	}
 
-	new_tries := make([]*Trie[T], new_tries_len)
+	contents := make([]T, 0, rest)
+	for _, leaf := range leaves[full:] {
+		contents = append(contents, leaf.content[:leaf.length]...)
+	}
+	new_tries := make([]*Trie[T], 0, full+new_tries_len)
+	new_tries = append(new_tries, leaves[:full]...)
+	for len(contents) > 0 {
+		size := plan.lf
+		switch {
+		case plan.ms > 0:
+			size = m
+			plan.ms--
+		case plan.mo > 0:
+			size = m-1
+			plan.mo--
+		}
+		leaf := NewTrie[T](0)
+		leaf.length = copy(leaf.content[:], contents[:size])
+		new_tries = append(new_tries, leaf)
+		contents = contents[size:]
+	}
+	return new_tries
+}
+
+// pack puts tries into as few tries with height as there can be, sharing
+// them out evenly
+func pack[T any](height int, tries []*Trie[T]) []*Trie[T] {
+	count := (len(tries) + m-1) / m
+	packed := make([]*Trie[T], count)
+	for i := range packed {
+		size := len(tries) / (count-i)
+		n := NewTrie[T](height)
+		n.length = copy(n.subtrie[:], tries[:size])
+		n.subsize = subsize[T](n.subtrie, n.length)
+		packed[i] = n
+		tries = tries[size:]
+	}
+	return packed
 }
 
+// lift puts t as the only subtrie of a trie one higher
+func lift[T any](t *Trie[T]) *Trie[T] {
+	n := NewTrie[T](t.height+1)
+	n.subtrie[0], n.subsize[0], n.length = t, t.Size(), 1
+	return n
+}
+
+// merge concatenates two tries of the same height, and returns the tries of
+// that height it packed the result into. Only the tries along the seam are
+// new, down to the leaves, which are reshuffled.
+func merge[T any](l, r *Trie[T]) []*Trie[T] {
+	assert(l.height == r.height && l.height > 0)
+	if l.height == 1 {
+		return pack(1, reshuffled_contents(l, r))
+	}
+	mid := merge(l.subtrie[l.length-1], r.subtrie[0])
+	tries := make([]*Trie[T], 0, l.length+len(mid)+r.length)
+	tries = append(tries, l.subtrie[:l.length-1]...)
+	tries = append(tries, mid...)
+	tries = append(tries, r.subtrie[1:r.length]...)
+	return pack(l.height, tries)
+}
+
+// Concat returns the elements of l followed by the ones of r, as a new
+// trie that shares every node of both except for the ones along the seam.
 func (l *Trie[T])Concat(r *Trie[T]) *Trie[T] {
+	if r.Size() == 0 {
+		return l
+	}
+	if l.Size() == 0 {
+		return r
+	}
+	height := max(l.height, r.height, 1)
+	for l.height < height {
+		l = lift(l)
+	}
+	for r.height < height {
+		r = lift(r)
+	}
+	tries := merge(l, r)
+	for len(tries) > 1 {
+		tries = pack(tries[0].height+1, tries)
+	}
+	return tries[0].shrink()
 }
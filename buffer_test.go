@@ -1,6 +1,7 @@
 package web
 
 import (
+	"bytes"
 	"math/rand"
 	"testing"
 	"io"
@@ -154,6 +155,184 @@ func TestDrop(t *testing.T) {
 }
 
 
+// checkTrie fails unless every subtrie is one lower than its parent, none
+// are empty or over m, and subsize adds up
+func checkTrie[T any](t *testing.T, a *Trie[T]) {
+	t.Helper()
+	var check func(n *Trie[T]) int
+	check = func(n *Trie[T]) int {
+		if n.length > m {
+			t.Fatalf("%v holds %d", n, n.length)
+		}
+		if n.height == 0 {
+			return n.length
+		}
+		size := 0
+		for i := 0; i < n.length; i++ {
+			c := n.subtrie[i]
+			if c.height != n.height-1 || c.Size() == 0 {
+				t.Fatalf("subtrie %v under %v", c, n)
+			}
+			size += check(c)
+			if n.subsize[i] != size {
+				t.Fatalf("%v has subsize %v", n, n.subsize[:n.length])
+			}
+		}
+		return size
+	}
+	if check(a) != a.Size() {
+		t.Fatalf("%v does not add up to its size", a)
+	}
+}
+
+func trieContents[T any](a *Trie[T]) []T {
+	var p []T
+	for i := a.Iterator(0); !i.Done(); i.NextTrie(0) {
+		leaf := i.Trie()
+		p = append(p, leaf.content[i.point:leaf.length]...)
+	}
+	return p
+}
+
+func TestConcat(t *testing.T) {
+	sizes := []int{1, 5, 31, 32, 33, 500, 1024, 1025, 3000, 40000}
+	for _, ls := range sizes {
+		for _, rs := range sizes {
+			l, r := randSeq(ls), randSeq(rs)
+			a, b := TrieFromSlice[byte](l), TrieFromSlice[byte](r)
+			c := a.Concat(b)
+			checkTrie(t, c)
+			if !bytes.Equal(trieContents(c), append(append([]byte(nil), l...), r...)) {
+				t.Fatalf("%d.Concat(%d) is wrong", ls, rs)
+			}
+			if !bytes.Equal(trieContents(a), l) || !bytes.Equal(trieContents(b), r) {
+				t.Fatalf("%d.Concat(%d) changed its parts", ls, rs)
+			}
+		}
+	}
+	a := TrieFromSlice[byte](randSeq(100))
+	if a.Concat(NewTrie[byte](0)) != a || NewTrie[byte](0).Concat(a) != a {
+		t.Fatalf("Concat with an empty trie made a new one")
+	}
+}
+
+// the leaves of full parts are not copied, only the nodes above them
+func TestConcatShares(t *testing.T) {
+	a := TrieFromSlice[byte](randSeq(m*m*4))
+	b := TrieFromSlice[byte](randSeq(m*m*4))
+	c := a.Concat(b)
+	leaves := map[*Trie[byte]]bool{}
+	for _, p := range []*Trie[byte]{a, b} {
+		for i := p.Iterator(0); !i.Done(); i.NextTrie(0) {
+			leaves[i.Trie()] = true
+		}
+	}
+	for i := c.Iterator(0); !i.Done(); i.NextTrie(0) {
+		if !leaves[i.Trie()] {
+			t.Fatalf("a leaf was copied")
+		}
+	}
+}
+
+func TestIterator(t *testing.T) {
+	ref := randSeq(3000)
+	a := TrieFromSlice[byte](ref[:1000]).Concat(TrieFromSlice[byte](ref[1000:]))
+	for _, start := range []int{0, 1, 31, 32, 999, 2999} {
+		var got []byte
+		for i := a.Iterator(start); !i.Done(); i.Next() {
+			got = append(got, i.Content())
+		}
+		if !bytes.Equal(got, ref[start:]) {
+			t.Fatalf("Iterator(%d) gave %d elements", start, len(got))
+		}
+	}
+	if !a.Iterator(3000).Done() || !NewTrie[byte](0).Iterator(0).Done() {
+		t.Fatalf("an iterator past the end is not done")
+	}
+	i := a.Iterator(40)
+	i.NextTrie(0)
+	if i.Content() != ref[64] || i.Trie().length != m {
+		t.Fatalf("NextTrie(0) went to %d", i.point)
+	}
+}
+
+func TestInsert(t *testing.T) {
+	num := 1000
+	ref := randSeq(num)
+	a := TrieFromSlice[byte](ref)
+	ins := []byte("inserted")
+	for _, i := range []int{0, 1, 31, 32, 500, num-1, num} {
+		b := a.Insert(0, i, ins)
+		if b.Size() != num+len(ins) {
+			t.Fatalf("a.Insert(%d).Size() = %d", i, b.Size())
+		}
+		want := append(append(append([]byte(nil), ref[:i]...), ins...), ref[i:]...)
+		got := make([]byte, b.Size())
+		if n := b.ReadInto(0, got); n != len(got) || !bytes.Equal(got, want) {
+			t.Fatalf("a.Insert(%d) = %s", i, got)
+		}
+	}
+	if a.Size() != num {
+		t.Fatalf("Insert changed a to size %d", a.Size())
+	}
+}
+
+func TestDelete(t *testing.T) {
+	num := 1000
+	ref := randSeq(num)
+	a := TrieFromSlice[byte](ref)
+	for _, c := range [][2]int{{0, 10}, {0, num}, {990, 10}, {100, 0}, {100, 600}, {1, 998}} {
+		b := a.Delete(0, c[0], c[1])
+		want := append(append([]byte(nil), ref[:c[0]]...), ref[c[0]+c[1]:]...)
+		got := make([]byte, b.Size())
+		if b.ReadInto(0, got); !bytes.Equal(got, want) {
+			t.Fatalf("a.Delete(%d, %d) = %s", c[0], c[1], got)
+		}
+	}
+}
+
+func TestReadInto(t *testing.T) {
+	num := 3000
+	ref := randSeq(num)
+	a := TrieFromSlice[byte](ref)
+	p := make([]byte, 77)
+	for i := 0; i < num; i += 13 {
+		n := a.ReadInto(i, p)
+		if n != min(len(p), num-i) || !bytes.Equal(p[:n], ref[i:i+n]) {
+			t.Fatalf("a.ReadInto(%d) = %d %s", i, n, p[:n])
+		}
+	}
+	if n := a.ReadInto(num, p); n != 0 {
+		t.Fatalf("a.ReadInto(%d) = %d", num, n)
+	}
+}
+
+// a long run of edits keeps the trie sound, and its leaves packed
+func TestEdits(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ref := randSeq(5000)
+	a := TrieFromSlice[byte](ref)
+	for i := 0; i < 5000; i++ {
+		at := rng.Intn(len(ref)+1)
+		if rng.Intn(3) == 0 && at < len(ref) {
+			n := min(rng.Intn(20)+1, len(ref)-at)
+			a = a.Delete(0, at, n)
+			ref = append(ref[:at:at], ref[at+n:]...)
+		} else {
+			ins := randSeq(rng.Intn(8)+1)
+			a = a.Insert(0, at, ins)
+			ref = append(append(ref[:at:at], ins...), ref[at:]...)
+		}
+	}
+	checkTrie(t, a)
+	if !bytes.Equal(trieContents(a), ref) {
+		t.Fatalf("the trie does not match after the edits")
+	}
+	if s := Stats(a); s.Fill < 0.7 {
+		t.Fatalf("leaves are %.2f full after the edits", s.Fill)
+	}
+}
+
 func BenchmarkAppendTrans(b *testing.B){
 	a := NewTrans[byte](0,1234)
 	s := []byte("This things what else is there to know")
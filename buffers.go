@@ -0,0 +1,315 @@
+/* The Buffer interface is what the editor and protocol layers hold on to, so
+   they can switch between backends and we can compare them under the same
+   tests. There are three:
+   + TrieBuffer, the persistent rrb trie. Snapshots are free.
+   + GapBuffer, a byte slice with a hole at the last edit. Edits close to each
+     other are cheap, a snapshot copies everything.
+   + PieceTable, the original text plus an append only buffer of everything
+     inserted, stitched together by a list of pieces. A snapshot copies the
+     pieces.
+ */
+package web
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrRange = errors.New("web: index out of range")
+
+type Buffer interface {
+	// ReadAt follows io.ReaderAt, it returns io.EOF once off+len(p) goes
+	// past the end.
+	io.ReaderAt
+	Len() int
+	Insert(off int, p []byte) error
+	Delete(off, n int) error
+	// Snapshot returns a copy that later edits to either side will not see.
+	Snapshot() Buffer
+}
+
+// Bytes reads the whole of buf into a new slice
+func Bytes(buf Buffer) []byte {
+	p := make([]byte, buf.Len())
+	buf.ReadAt(p, 0)
+	return p
+}
+
+func check_range(buf Buffer, off, n int) error {
+	if off < 0 || n < 0 || off+n > buf.Len() {
+		return ErrRange
+	}
+	return nil
+}
+
+func read_result(n, want int) (int, error) {
+	if n < want {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type TrieBuffer struct {
+	t *Trie[byte]
+}
+
+func NewTrieBuffer(p []byte) *TrieBuffer {
+	t := TrieFromSlice[byte](p)
+	t.id = 0
+	return &TrieBuffer{t}
+}
+
+// TrieBufferOf wraps an existing trie, which must not be edited in place
+// afterwards.
+func TrieBufferOf(t *Trie[byte]) *TrieBuffer {
+	return &TrieBuffer{t}
+}
+
+// Trie returns the current root. It is persistent, so holding on to it is as
+// good as a snapshot.
+func (tb *TrieBuffer)Trie() *Trie[byte] {
+	return tb.t
+}
+
+func (tb *TrieBuffer)Len() int {
+	return tb.t.Size()
+}
+
+func (tb *TrieBuffer)ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrRange
+	}
+	if off >= int64(tb.t.Size()) {
+		return read_result(0, len(p))
+	}
+	return read_result(tb.t.ReadInto(int(off), p), len(p))
+}
+
+func (tb *TrieBuffer)Insert(off int, p []byte) error {
+	if err := check_range(tb, off, 0); err != nil {
+		return err
+	}
+	tb.t = tb.t.Insert(0, off, p)
+	return nil
+}
+
+func (tb *TrieBuffer)Delete(off, n int) error {
+	if err := check_range(tb, off, n); err != nil {
+		return err
+	}
+	tb.t = tb.t.Delete(0, off, n)
+	return nil
+}
+
+func (tb *TrieBuffer)Snapshot() Buffer {
+	return &TrieBuffer{tb.t}
+}
+
+// space the gap buffer leaves for typing when it has to grow
+const gap_size = 1024
+
+type GapBuffer struct {
+	buf    []byte
+	gs, ge int // the gap is buf[gs:ge]
+}
+
+func NewGapBuffer(p []byte) *GapBuffer {
+	g := &GapBuffer{}
+	g.buf = make([]byte, len(p)+gap_size)
+	g.ge = gap_size
+	copy(g.buf[g.ge:], p)
+	return g
+}
+
+func (g *GapBuffer)Len() int {
+	return len(g.buf) - (g.ge-g.gs)
+}
+
+// move puts the gap at off
+func (g *GapBuffer)move(off int) {
+	if off < g.gs {
+		n := copy(g.buf[g.ge-(g.gs-off):], g.buf[off:g.gs])
+		g.gs, g.ge = off, g.ge-n
+	} else if off > g.gs {
+		n := copy(g.buf[g.gs:], g.buf[g.ge:g.ge+(off-g.gs)])
+		g.gs, g.ge = g.gs+n, g.ge+n
+	}
+}
+
+// grow makes room for at least n more bytes in the gap
+func (g *GapBuffer)grow(n int) {
+	if g.ge-g.gs >= n {
+		return
+	}
+	size := len(g.buf) + n + max(gap_size, len(g.buf)/2)
+	nb := make([]byte, size)
+	copy(nb, g.buf[:g.gs])
+	tail := len(g.buf)-g.ge
+	copy(nb[size-tail:], g.buf[g.ge:])
+	g.buf, g.ge = nb, size-tail
+}
+
+func (g *GapBuffer)ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrRange
+	}
+	i := int(off)
+	if i >= g.Len() {
+		return read_result(0, len(p))
+	}
+	c := 0
+	if i < g.gs {
+		c = copy(p, g.buf[i:g.gs])
+		i = g.gs
+	}
+	c += copy(p[c:], g.buf[g.ge+(i-g.gs):])
+	return read_result(c, len(p))
+}
+
+func (g *GapBuffer)Insert(off int, p []byte) error {
+	if err := check_range(g, off, 0); err != nil {
+		return err
+	}
+	g.grow(len(p))
+	g.move(off)
+	copy(g.buf[g.gs:], p)
+	g.gs += len(p)
+	return nil
+}
+
+func (g *GapBuffer)Delete(off, n int) error {
+	if err := check_range(g, off, n); err != nil {
+		return err
+	}
+	g.move(off)
+	g.ge += n
+	return nil
+}
+
+func (g *GapBuffer)Snapshot() Buffer {
+	return &GapBuffer{append([]byte(nil), g.buf...), g.gs, g.ge}
+}
+
+type span struct {
+	added      bool // from the add buffer rather than the original
+	start, len int
+}
+
+type PieceTable struct {
+	orig, add []byte
+	pieces    []span
+	length    int
+}
+
+// NewPieceTable keeps p as the original text, so p must not change after.
+func NewPieceTable(p []byte) *PieceTable {
+	pt := &PieceTable{orig: p, length: len(p)}
+	if len(p) > 0 {
+		pt.pieces = []span{{false, 0, len(p)}}
+	}
+	return pt
+}
+
+func (pt *PieceTable)Len() int {
+	return pt.length
+}
+
+func (pt *PieceTable)text(p span) []byte {
+	if p.added {
+		return pt.add[p.start:p.start+p.len]
+	}
+	return pt.orig[p.start:p.start+p.len]
+}
+
+// split makes a piece start at off and returns its index
+func (pt *PieceTable)split(off int) int {
+	for k, p := range pt.pieces {
+		if off == 0 {
+			return k
+		}
+		if off < p.len {
+			l := span{p.added, p.start, off}
+			r := span{p.added, p.start+off, p.len-off}
+			pt.pieces = append(pt.pieces[:k+1], pt.pieces[k:]...)
+			pt.pieces[k], pt.pieces[k+1] = l, r
+			return k+1
+		}
+		off -= p.len
+	}
+	return len(pt.pieces)
+}
+
+func (pt *PieceTable)ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrRange
+	}
+	i := int(off)
+	c := 0
+	for _, pc := range pt.pieces {
+		if c == len(p) {
+			break
+		}
+		if i >= pc.len {
+			i -= pc.len
+			continue
+		}
+		c += copy(p[c:], pt.text(pc)[i:])
+		i = 0
+	}
+	return read_result(c, len(p))
+}
+
+func (pt *PieceTable)Insert(off int, p []byte) error {
+	if err := check_range(pt, off, 0); err != nil {
+		return err
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	k := pt.split(off)
+	// typing appends to the piece that was just added, so grow it instead of
+	// making a new one for every key
+	if k > 0 {
+		last := &pt.pieces[k-1]
+		if last.added && last.start+last.len == len(pt.add) {
+			pt.add = append(pt.add, p...)
+			last.len += len(p)
+			pt.length += len(p)
+			return nil
+		}
+	}
+	pc := span{true, len(pt.add), len(p)}
+	pt.add = append(pt.add, p...)
+	pt.pieces = append(pt.pieces, span{})
+	copy(pt.pieces[k+1:], pt.pieces[k:])
+	pt.pieces[k] = pc
+	pt.length += len(p)
+	return nil
+}
+
+func (pt *PieceTable)Delete(off, n int) error {
+	if err := check_range(pt, off, n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	k := pt.split(off)
+	e := pt.split(off+n)
+	pt.pieces = append(pt.pieces[:k], pt.pieces[e:]...)
+	pt.length -= n
+	return nil
+}
+
+// Snapshot shares the original and add buffers. Both are only ever appended
+// to, and the add buffer is capped so that the two sides never append into
+// the same array.
+func (pt *PieceTable)Snapshot() Buffer {
+	pt.add = pt.add[:len(pt.add):len(pt.add)]
+	return &PieceTable{
+		orig:   pt.orig,
+		add:    pt.add,
+		pieces: append([]span(nil), pt.pieces...),
+		length: pt.length,
+	}
+}
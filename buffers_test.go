@@ -0,0 +1,102 @@
+package web
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// every backend runs the same trace as the slice and has to end up with the
+// same bytes
+func TestBuffersTrace(t *testing.T) {
+	doc := randSeq(5000)
+	r := rand.New(rand.NewSource(2))
+	trace := append(typingTrace(r)[:1000], randomDeleteTrace(r)[:1000]...)
+	trace = append(trace, randomInsertTrace(r)[:500]...)
+	trace = append(trace, pasteTrace(r)[:50]...)
+	want := &sliceBuffer{append([]byte(nil), doc...)}
+	for _, e := range trace {
+		e.apply(want)
+	}
+	for _, e := range backends {
+		buf := e.new(doc)
+		for _, tr := range trace {
+			tr.apply(buf)
+		}
+		if buf.Len() != want.Len() {
+			t.Fatalf("%s: Len() = %d != %d", e.name, buf.Len(), want.Len())
+		}
+		if !bytes.Equal(Bytes(buf), want.buf) {
+			t.Fatalf("%s: contents differ from the slice", e.name)
+		}
+	}
+}
+
+func TestBuffersSnapshot(t *testing.T) {
+	for _, e := range backends {
+		buf := e.new([]byte("hello world"))
+		snap := buf.Snapshot()
+		buf.Insert(5, []byte(","))
+		buf.Delete(0, 1)
+		snap.Insert(11, []byte("!"))
+		if got := string(Bytes(buf)); got != "ello, world" {
+			t.Fatalf("%s: buf = %q", e.name, got)
+		}
+		if got := string(Bytes(snap)); got != "hello world!" {
+			t.Fatalf("%s: snap = %q", e.name, got)
+		}
+
+		// and again, now that both sides have an edit history
+		again := buf.Snapshot()
+		buf.Insert(buf.Len(), []byte("?"))
+		again.Insert(again.Len(), []byte("."))
+		if got := string(Bytes(buf)); got != "ello, world?" {
+			t.Fatalf("%s: buf = %q", e.name, got)
+		}
+		if got := string(Bytes(again)); got != "ello, world." {
+			t.Fatalf("%s: again = %q", e.name, got)
+		}
+	}
+}
+
+func TestBuffersReadAt(t *testing.T) {
+	ref := randSeq(3000)
+	for _, e := range backends {
+		buf := e.new(ref)
+		p := make([]byte, 100)
+		n, err := buf.ReadAt(p, 1000)
+		if n != 100 || err != nil || !bytes.Equal(p, ref[1000:1100]) {
+			t.Fatalf("%s: ReadAt(1000) = %d, %v", e.name, n, err)
+		}
+		n, err = buf.ReadAt(p, 2950)
+		if n != 50 || err != io.EOF || !bytes.Equal(p[:n], ref[2950:]) {
+			t.Fatalf("%s: ReadAt(2950) = %d, %v", e.name, n, err)
+		}
+		n, err = buf.ReadAt(p, 3000)
+		if n != 0 || err != io.EOF {
+			t.Fatalf("%s: ReadAt(3000) = %d, %v", e.name, n, err)
+		}
+	}
+}
+
+func TestBuffersRange(t *testing.T) {
+	for _, e := range backends[1:] {
+		buf := e.new([]byte("abc"))
+		if err := buf.Insert(4, []byte("x")); err != ErrRange {
+			t.Fatalf("%s: Insert past the end returned %v", e.name, err)
+		}
+		if err := buf.Delete(2, 2); err != ErrRange {
+			t.Fatalf("%s: Delete past the end returned %v", e.name, err)
+		}
+		if err := buf.Delete(-1, 1); err != ErrRange {
+			t.Fatalf("%s: Delete before the start returned %v", e.name, err)
+		}
+		if err := buf.Delete(0, 3); err != nil || buf.Len() != 0 {
+			t.Fatalf("%s: Delete everything returned %v, Len %d", e.name, err, buf.Len())
+		}
+		if err := buf.Insert(0, []byte("again")); err != nil || string(Bytes(buf)) != "again" {
+			t.Fatalf("%s: Insert into empty returned %v", e.name, err)
+		}
+	}
+}
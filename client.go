@@ -0,0 +1,156 @@
+/* Client is the other half of a Session, for programs that edit along with
+   people: it keeps a local Trie[byte] that local edits change straight away,
+   and works the ops of others in as they arrive.
+   A client has at most one op out with the server at a time. It is in one
+   of three states:
+   + synchronized, nothing is out, a local edit is sent at once
+   + awaiting confirm, one op is out, local edits wait
+   + awaiting with buffer, one op is out and the edits made since wait in a
+     buffer, composed into one op, to be sent when the server takes the first
+   An op from someone else was made without the ones we have not had
+   confirmed, so it is transformed past them before it is applied, and they
+   are transformed past it in turn. The server's op is always the first
+   argument to Transform, as it is on the server.
+ */
+package web
+
+import (
+	"sync"
+)
+
+type ClientState int
+
+const (
+	ClientSynchronized ClientState = iota
+	ClientAwaitingConfirm
+	ClientAwaitingWithBuffer
+)
+
+func (s ClientState)String() string {
+	return [...]string{"synchronized", "awaiting confirm", "awaiting with buffer"}[s]
+}
+
+type Client struct {
+	id   string
+	mu   sync.Mutex
+	rev  int
+	text *Trie[byte]
+	// the op out with the server, and the one waiting to go
+	state       ClientState
+	outstanding Op
+	buffer      Op
+	send        func(base int, op Op)
+}
+
+// NewClient makes client id, which has text as of revision rev. send is
+// called with every op to send to the server, in order, and with the client
+// unlocked.
+func NewClient(id string, rev int, text *Trie[byte], send func(base int, op Op)) *Client {
+	return &Client{id: id, rev: rev, text: text, send: send}
+}
+
+// Text returns the local text and the last revision of the server in it.
+func (c *Client)Text() (*Trie[byte], int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.text, c.rev
+}
+
+func (c *Client)State() ClientState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Edit applies a local op to the text, and sends it or keeps it for later.
+func (c *Client)Edit(op Op) error {
+	c.mu.Lock()
+	text, err := op.Apply(c.text)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.text = text
+	send := false
+	switch c.state {
+	case ClientSynchronized:
+		c.outstanding, c.state, send = op, ClientAwaitingConfirm, true
+	case ClientAwaitingConfirm:
+		c.buffer, c.state = op, ClientAwaitingWithBuffer
+	case ClientAwaitingWithBuffer:
+		if c.buffer, err = Compose(c.buffer, op); err != nil {
+			panic("web: the buffer does not compose with an edit that applied")
+		}
+	}
+	rev := c.rev
+	c.mu.Unlock()
+	if send {
+		c.send(rev, op)
+	}
+	return nil
+}
+
+// Receive takes the next revision from the server, which is either the
+// confirmation of our op or someone else's op.
+func (c *Client)Receive(r Revision) error {
+	c.mu.Lock()
+	if r.Rev != c.rev+1 {
+		c.mu.Unlock()
+		return ErrRevision
+	}
+	var send *Op
+	var err error
+	if r.Client == c.id && c.state != ClientSynchronized {
+		send = c.ack()
+	} else {
+		err = c.remote(r.Op)
+	}
+	if err == nil {
+		c.rev = r.Rev
+	}
+	rev := c.rev
+	c.mu.Unlock()
+	if send != nil {
+		c.send(rev, *send)
+	}
+	return err
+}
+
+// ack moves on from the outstanding op, and returns the op to send next
+func (c *Client)ack() *Op {
+	switch c.state {
+	case ClientAwaitingConfirm:
+		c.outstanding, c.state = Op{}, ClientSynchronized
+	case ClientAwaitingWithBuffer:
+		c.outstanding, c.buffer, c.state = c.buffer, Op{}, ClientAwaitingConfirm
+		op := c.outstanding
+		return &op
+	}
+	return nil
+}
+
+// remote applies an op from someone else. The client is as it was if that
+// fails, so the server can be asked again.
+func (c *Client)remote(op Op) error {
+	outstanding, buffer := c.outstanding, c.buffer
+	var err error
+	switch c.state {
+	case ClientAwaitingConfirm:
+		if op, outstanding, err = Transform(op, outstanding); err != nil {
+			return err
+		}
+	case ClientAwaitingWithBuffer:
+		if op, outstanding, err = Transform(op, outstanding); err != nil {
+			return err
+		}
+		if op, buffer, err = Transform(op, buffer); err != nil {
+			return err
+		}
+	}
+	text, err := op.Apply(c.text)
+	if err != nil {
+		return err
+	}
+	c.text, c.outstanding, c.buffer = text, outstanding, buffer
+	return nil
+}
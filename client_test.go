@@ -0,0 +1,175 @@
+package web
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type sent struct {
+	base int
+	op   Op
+}
+
+// sim_client is a client with its end of the network, messages wait in
+// queues until the test delivers them
+type sim_client struct {
+	*Client
+	inbox  []Revision
+	outbox []sent
+}
+
+func newSimClients(s *Session, n int) []*sim_client {
+	text, rev := s.Snapshot()
+	cs := make([]*sim_client, n)
+	for i := range cs {
+		c := &sim_client{}
+		c.Client = NewClient(fmt.Sprint(i), rev, text, func(base int, op Op) {
+			c.outbox = append(c.outbox, sent{base, op})
+		})
+		s.Subscribe(func(r Revision) { c.inbox = append(c.inbox, r) })
+		cs[i] = c
+	}
+	return cs
+}
+
+func (c *sim_client)upload(t *testing.T, s *Session) {
+	m := c.outbox[0]
+	c.outbox = c.outbox[1:]
+	if _, err := s.Receive(c.id, m.base, m.op); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *sim_client)download(t *testing.T) {
+	r := c.inbox[0]
+	c.inbox = c.inbox[1:]
+	if err := c.Receive(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientStates(t *testing.T) {
+	s := NewSession(TrieFromSlice[byte]([]byte("abc")))
+	cs := newSimClients(s, 2)
+	a, b := cs[0], cs[1]
+
+	a.Edit(ReplaceOp(3, 3, 0, []byte("d")))
+	if a.State() != ClientAwaitingConfirm || len(a.outbox) != 1 {
+		t.Fatalf("after an edit: %v, %d sent", a.State(), len(a.outbox))
+	}
+	a.Edit(ReplaceOp(4, 0, 0, []byte("<")))
+	a.Edit(ReplaceOp(5, 5, 0, []byte(">")))
+	if a.State() != ClientAwaitingWithBuffer || len(a.outbox) != 1 {
+		t.Fatalf("after more edits: %v, %d sent", a.State(), len(a.outbox))
+	}
+
+	// b's edit gets to the server first
+	b.Edit(ReplaceOp(3, 1, 1, []byte("B")))
+	b.upload(t, s)
+	a.upload(t, s)
+	a.download(t)
+	if text, rev := a.Text(); trieString(text) != "<aBcd>" || rev != 1 {
+		t.Fatalf("a has %q at %d after b's op", trieString(text), rev)
+	}
+	a.download(t)
+	if a.State() != ClientAwaitingConfirm || len(a.outbox) != 1 {
+		t.Fatalf("after the ack: %v, %d to send", a.State(), len(a.outbox))
+	}
+	a.upload(t, s)
+	a.download(t)
+	if a.State() != ClientSynchronized {
+		t.Fatalf("after the last ack: %v", a.State())
+	}
+	for len(b.inbox) > 0 {
+		b.download(t)
+	}
+	text, _ := s.Snapshot()
+	for _, c := range cs {
+		if got, _ := c.Text(); trieString(got) != trieString(text) {
+			t.Fatalf("client %s has %q, the session %q", c.id, trieString(got), trieString(text))
+		}
+	}
+
+	if err := a.Receive(Revision{Rev: 7, Op: ReplaceOp(6, 0, 0, nil)}); err != ErrRevision {
+		t.Fatalf("a revision out of order: %v", err)
+	}
+}
+
+// a bad op from the server leaves the client as it was
+func TestClientBadRemote(t *testing.T) {
+	c := NewClient("a", 0, TrieFromSlice[byte]([]byte("abc")), func(int, Op) {})
+	c.Edit(ReplaceOp(3, 3, 0, []byte("d")))
+	c.Edit(ReplaceOp(4, 0, 0, []byte("<")))
+	outstanding, buffer := c.outstanding, c.buffer
+	for _, op := range []Op{ReplaceOp(10, 0, 0, []byte("x")), ReplaceOp(2, 0, 0, []byte("x"))} {
+		if err := c.Receive(Revision{1, "b", op}); err == nil {
+			t.Fatalf("a remote op for the wrong text went in")
+		}
+		if text, rev := c.Text(); trieString(text) != "<abcd" || rev != 0 {
+			t.Fatalf("after a bad op the client has %q at %d", trieString(text), rev)
+		}
+		if c.State() != ClientAwaitingWithBuffer || !reflect.DeepEqual(c.outstanding, outstanding) ||
+			!reflect.DeepEqual(c.buffer, buffer) {
+			t.Fatalf("after a bad op the client waits with %v and %v", c.outstanding, c.buffer)
+		}
+	}
+	// the right one still goes in
+	if err := c.Receive(Revision{1, "b", ReplaceOp(3, 3, 0, []byte("!"))}); err != nil {
+		t.Fatal(err)
+	}
+	if text, rev := c.Text(); trieString(text) != "<abc!d" && trieString(text) != "<abcd!" || rev != 1 {
+		t.Fatalf("after the op the client has %q at %d", trieString(text), rev)
+	}
+}
+
+// clients edit at random while messages are delivered in a random order, and
+// once they are all delivered everyone has the same text
+func TestClientConvergence(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		s := NewSession(randomText(rng, 20))
+		cs := newSimClients(s, 4)
+		for i := 0; i < 300; i++ {
+			c := cs[rng.Intn(len(cs))]
+			switch rng.Intn(3) {
+			case 0:
+				text, _ := c.Text()
+				if err := c.Edit(randomOp(rng, text.Size())); err != nil {
+					t.Fatal(err)
+				}
+			case 1:
+				if len(c.outbox) > 0 {
+					c.upload(t, s)
+				}
+			case 2:
+				if len(c.inbox) > 0 {
+					c.download(t)
+				}
+			}
+		}
+		for busy := true; busy; {
+			busy = false
+			for _, c := range cs {
+				for len(c.outbox) > 0 || len(c.inbox) > 0 {
+					busy = true
+					if len(c.outbox) > 0 {
+						c.upload(t, s)
+					}
+					if len(c.inbox) > 0 {
+						c.download(t)
+					}
+				}
+			}
+		}
+		text, rev := s.Snapshot()
+		for _, c := range cs {
+			got, crev := c.Text()
+			if trieString(got) != trieString(text) || crev != rev || c.State() != ClientSynchronized {
+				t.Fatalf("seed %d: client %s has %q at %d, %v; the session %q at %d",
+					seed, c.id, trieString(got), crev, c.State(), trieString(text), rev)
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+/* web-lsp serves the documents of package web to editors over the language
+   server protocol, on stdin and stdout. Logs go to stderr.
+   With -stats it also serves the memory held by the versions of every open
+   document as JSON over HTTP, at /debug/tries, for monitoring.
+ */
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"web"
+)
+
+func main() {
+	stats := flag.String("stats", "", "address to serve /debug/tries on, such as localhost:6060")
+	flag.Parse()
+
+	s := web.NewLSPServer(web.DefaultRegistry())
+	if *stats != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/tries", web.StatsHandler(s.Project().Texts))
+		go func() {
+			log.Fatal(http.ListenAndServe(*stats, mux))
+		}()
+	}
+	if err := s.Serve(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,157 @@
+/* Generic operations over tries of any element type, so per-line metadata
+   such as diagnostics or blame can be kept in a Trie of structs alongside
+   the Trie[byte] holding the text.
+   All of these leave their input alone and return a new trie.
+ */
+package web
+
+import (
+	"iter"
+	"math/rand"
+)
+
+// All iterates over the index and value of every element in order.
+func (t *Trie[T])All() iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		t.eachLeaf(0, func(offset int, leaf []T) bool {
+			for i, v := range leaf {
+				if !yield(offset+i, v) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+}
+
+// Values iterates over every element in order.
+func (t *Trie[T])Values() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		t.eachLeaf(0, func(_ int, leaf []T) bool {
+			for _, v := range leaf {
+				if !yield(v) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+}
+
+// eachLeafBackward is eachLeaf from the last leaf to the first
+func (t *Trie[T])eachLeafBackward(offset int, f func(offset int, leaf []T) bool) bool {
+	if t.height == 0 {
+		if t.length == 0 {
+			return true
+		}
+		return f(offset, t.content[:t.length])
+	}
+	for i := t.length-1; i >= 0; i-- {
+		start := offset
+		if i > 0 {
+			start += t.subsize[i-1]
+		}
+		if !t.subtrie[i].eachLeafBackward(start, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// Backward iterates over the index and value of every element, starting
+// from the last one.
+func (t *Trie[T])Backward() iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		t.eachLeafBackward(0, func(offset int, leaf []T) bool {
+			for i := len(leaf)-1; i >= 0; i-- {
+				if !yield(offset+i, leaf[i]) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+}
+
+// TrieFromSeq builds a persistent trie out of everything seq yields.
+func TrieFromSeq[T any](seq iter.Seq[T]) *Trie[T] {
+	n := NewTrans[T](0, rand.Int())
+	for v := range seq {
+		n = n.Append(n.id, v)
+	}
+	n.id = 0
+	return n
+}
+
+// Map returns a trie of the same shape as t with f applied to every element.
+func Map[T, U any](t *Trie[T], f func(T) U) *Trie[U] {
+	return new_mapped_nodes(f).get(t)
+}
+
+// Filter returns a trie of the elements of t that keep returns true for.
+func Filter[T any](t *Trie[T], keep func(T) bool) *Trie[T] {
+	return TrieFromSeq(func(yield func(T) bool) {
+		for v := range t.Values() {
+			if keep(v) && !yield(v) {
+				return
+			}
+		}
+	})
+}
+
+// Fold calls f on every element in order, threading acc through.
+func Fold[T, A any](t *Trie[T], acc A, f func(acc A, v T) A) A {
+	t.eachLeaf(0, func(_ int, leaf []T) bool {
+		for _, v := range leaf {
+			acc = f(acc, v)
+		}
+		return true
+	})
+	return acc
+}
+
+// Reverse returns a trie with the elements of t in the opposite order.
+func Reverse[T any](t *Trie[T]) *Trie[T] {
+	return TrieFromSeq(func(yield func(T) bool) {
+		for _, v := range t.Backward() {
+			if !yield(v) {
+				return
+			}
+		}
+	})
+}
+
+// IndexFunc returns the index of the first element f returns true for, or -1.
+func IndexFunc[T any](t *Trie[T], f func(T) bool) int {
+	for i, v := range t.All() {
+		if f(v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// IndexOf returns the index of the first element equal to v, or -1.
+func IndexOf[T comparable](t *Trie[T], v T) int {
+	return IndexFunc(t, func(w T) bool { return w == v })
+}
+
+// Equal reports whether a and b hold the same elements in the same order.
+// Tries that share a root are equal without looking at their elements.
+func Equal[T comparable](a, b *Trie[T]) bool {
+	if a == b {
+		return true
+	}
+	if a.Size() != b.Size() {
+		return false
+	}
+	next, stop := iter.Pull(b.Values())
+	defer stop()
+	for v := range a.Values() {
+		w, ok := next()
+		if !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package web
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// the sort of thing we keep one of per line next to the text
+type diagnostic struct {
+	line     int
+	severity int
+	msg      string
+}
+
+func diagnostics(n int) []diagnostic {
+	ds := make([]diagnostic, n)
+	for i := range ds {
+		ds[i] = diagnostic{i, i%3, string(letters[i%len(letters)])}
+	}
+	return ds
+}
+
+func TestAllValues(t *testing.T) {
+	ref := randSeq(1500)
+	a := TrieFromSlice[byte](ref)
+	got := []byte{}
+	for i, v := range a.All() {
+		if i != len(got) {
+			t.Fatalf("All yielded index %d at position %d", i, len(got))
+		}
+		got = append(got, v)
+	}
+	if !bytes.Equal(got, ref) {
+		t.Fatalf("All does not match the reference")
+	}
+	if !bytes.Equal(slices.Collect(a.Values()), ref) {
+		t.Fatalf("Values does not match the reference")
+	}
+
+	n := 0
+	for range a.Values() {
+		n++
+		if n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Fatalf("break out of Values stopped at %d", n)
+	}
+}
+
+func TestBackward(t *testing.T) {
+	ref := randSeq(1100)
+	a := TrieFromSlice[byte](ref)
+	want := len(ref)-1
+	for i, v := range a.Backward() {
+		if i != want || v != ref[i] {
+			t.Fatalf("Backward yielded %d:%c, want %d:%c", i, v, want, ref[want])
+		}
+		want--
+	}
+	if want != -1 {
+		t.Fatalf("Backward stopped early at %d", want)
+	}
+}
+
+func TestMapStructs(t *testing.T) {
+	ds := diagnostics(2000)
+	a := TrieFromSlice[diagnostic](ds)
+	lines := Map(a, func(d diagnostic) int { return d.line })
+	for i, l := range lines.All() {
+		if l != i {
+			t.Fatalf("lines[%d] = %d", i, l)
+		}
+	}
+	if lines.Size() != len(ds) {
+		t.Fatalf("lines.Size() = %d != %d", lines.Size(), len(ds))
+	}
+}
+
+func TestFilter(t *testing.T) {
+	ds := diagnostics(3000)
+	a := TrieFromSlice[diagnostic](ds)
+	errs := Filter(a, func(d diagnostic) bool { return d.severity == 2 })
+	want := 0
+	for _, d := range ds {
+		if d.severity == 2 {
+			want++
+		}
+	}
+	if errs.Size() != want {
+		t.Fatalf("errs.Size() = %d != %d", errs.Size(), want)
+	}
+	for d := range errs.Values() {
+		if d.severity != 2 {
+			t.Fatalf("Filter kept %v", d)
+		}
+	}
+	if a.Size() != len(ds) {
+		t.Fatalf("Filter changed its input")
+	}
+	none := Filter(a, func(d diagnostic) bool { return false })
+	if none.Size() != 0 {
+		t.Fatalf("none.Size() = %d", none.Size())
+	}
+}
+
+func TestFold(t *testing.T) {
+	ref := randSeq(1000)
+	a := TrieFromSlice[byte](ref)
+	sum := Fold(a, 0, func(acc int, v byte) int { return acc + int(v) })
+	want := 0
+	for _, v := range ref {
+		want += int(v)
+	}
+	if sum != want {
+		t.Fatalf("sum = %d != %d", sum, want)
+	}
+}
+
+func TestReverse(t *testing.T) {
+	ref := randSeq(1234)
+	a := TrieFromSlice[byte](ref)
+	r := Reverse(a)
+	rev := slices.Clone(ref)
+	slices.Reverse(rev)
+	if !bytes.Equal(slices.Collect(r.Values()), rev) {
+		t.Fatalf("Reverse does not match the reference")
+	}
+	if !Equal(Reverse(r), a) {
+		t.Fatalf("Reverse(Reverse(a)) != a")
+	}
+}
+
+func TestIndexOf(t *testing.T) {
+	a := TrieFromSlice[byte](append(bytes.Repeat([]byte("a"), 700), 'b', 'c', 'b'))
+	if i := IndexOf(a, 'b'); i != 700 {
+		t.Fatalf("IndexOf(b) = %d", i)
+	}
+	if i := IndexOf(a, 'z'); i != -1 {
+		t.Fatalf("IndexOf(z) = %d", i)
+	}
+	ds := TrieFromSlice[diagnostic](diagnostics(100))
+	if i := IndexFunc(ds, func(d diagnostic) bool { return d.line == 42 }); i != 42 {
+		t.Fatalf("IndexFunc = %d", i)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	ref := randSeq(2000)
+	a := TrieFromSlice[byte](ref)
+	b := TrieFromSlice[byte](slices.Clone(ref))
+	if !Equal(a, a) || !Equal(a, b) {
+		t.Fatalf("equal tries compare unequal")
+	}
+	ref[1999] ^= 1
+	c := TrieFromSlice[byte](ref)
+	if Equal(a, c) {
+		t.Fatalf("tries differing in the last element compare equal")
+	}
+	if Equal(a, a.Take(0, 1000)) {
+		t.Fatalf("tries of different size compare equal")
+	}
+	if !Equal(NewTrie[byte](0), NewTrie[byte](1)) {
+		t.Fatalf("empty tries compare unequal")
+	}
+}
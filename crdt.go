@@ -0,0 +1,317 @@
+/* RGA is a replicated growable array, a sequence CRDT, for pages edited by
+   people that are not connected to anything. Every replica keeps the whole
+   text and edits it on its own, and replicas that swap their ops end up
+   with the same text no matter the order they get them in. No server
+   decides anything.
+   Every byte ever inserted has an id, a Lamport clock and the replica that
+   made it, and is inserted after some byte, its origin. A byte goes right
+   after its origin, past any bytes with greater ids there, which were
+   inserted after it concurrently and so go first. Deleted bytes stay as
+   tombstones so later ops can still find them.
+   The bytes are kept in a slice in text order, tombstones included, so an
+   op costs time linear in the size of the page, tombstones and all, plus
+   the bytes it inserts or deletes. The visible text is kept as a
+   Trie[byte] next to it, so snapshots are free.
+   Ops from a replica are applied in the order it made them, and ops that
+   refer to bytes we have not seen yet wait until we have.
+   Tombstones and the op log are never collected. A tombstone can only go
+   once every replica has seen its delete, or a late insert could have
+   nothing to go after, and a replica can not know that without knowing
+   every other replica, which nothing here does. Pages that see a lot of
+   editing should be started over from their text now and then, as a new
+   RGA every replica moves to.
+ */
+package web
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrRGAOp = errors.New("web: bad RGA op")
+
+// RGAID names a byte. The zero RGAID is the start of the text.
+type RGAID struct {
+	Clock   uint64 `json:"clock"`
+	Replica string `json:"replica"`
+}
+
+func (a RGAID)after(b RGAID) bool {
+	return a.Clock > b.Clock || (a.Clock == b.Clock && a.Replica > b.Replica)
+}
+
+// RGAOp is an insert of a run of bytes or a delete of some. The bytes of an
+// insert get the clocks ID.Clock, ID.Clock+1 and so on, and each one goes
+// after the one before it, the first after Origin.
+type RGAOp struct {
+	Replica string  `json:"replica"`
+	Seq     int     `json:"seq"` // 1 for the first op of the replica
+	ID      RGAID   `json:"id"`
+	Origin  RGAID   `json:"origin"`
+	Insert  []byte  `json:"insert,omitempty"`
+	Delete  []RGAID `json:"delete,omitempty"`
+}
+
+type rga_elem struct {
+	id      RGAID
+	b       byte
+	deleted bool
+}
+
+type RGA struct {
+	replica string
+	mu      sync.Mutex
+	clock   uint64
+	elems   []rga_elem
+	text    *Trie[byte]
+	// the ops applied, and how many of each replica
+	log     []RGAOp
+	seen    map[string]int
+	pending []RGAOp
+}
+
+// NewRGA makes an empty text for replica, whose name must be unique.
+func NewRGA(replica string) *RGA {
+	return &RGA{replica: replica, text: TrieFromSlice[byte](nil), seen: map[string]int{}}
+}
+
+func (r *RGA)Text() *Trie[byte] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.text
+}
+
+// Version returns how many ops of each replica have been applied.
+func (r *RGA)Version() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v := make(map[string]int, len(r.seen))
+	for k, n := range r.seen {
+		v[k] = n
+	}
+	return v
+}
+
+// Since returns the ops applied here that a replica at version v is missing.
+func (r *RGA)Since(v map[string]int) []RGAOp {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []RGAOp
+	for _, op := range r.log {
+		if op.Seq > v[op.Replica] {
+			out = append(out, op)
+		}
+	}
+	return out
+}
+
+// Merge applies the ops of o that r is missing.
+func (r *RGA)Merge(o *RGA) (Op, error) {
+	return r.Apply(o.Since(r.Version())...)
+}
+
+// visible returns the offset in the text of elems[i]
+func (r *RGA)visible(i int) int {
+	n := 0
+	for _, e := range r.elems[:i] {
+		if !e.deleted {
+			n++
+		}
+	}
+	return n
+}
+
+// at returns the index in elems of the byte at off in the text, which is
+// len(elems) for the end of the text
+func (r *RGA)at(off int) int {
+	for i, e := range r.elems {
+		if !e.deleted {
+			if off == 0 {
+				return i
+			}
+			off--
+		}
+	}
+	return len(r.elems)
+}
+
+func (r *RGA)index(id RGAID) (int, bool) {
+	if id == (RGAID{}) {
+		return -1, true
+	}
+	for i, e := range r.elems {
+		if e.id == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Insert inserts p at off in the text, and returns the op to send to the
+// other replicas. Inserting nothing makes no op, just the zero RGAOp, which
+// Apply skips.
+func (r *RGA)Insert(off int, p []byte) (RGAOp, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if off < 0 || off > r.text.Size() {
+		return RGAOp{}, ErrRange
+	}
+	if len(p) == 0 {
+		return RGAOp{}, nil
+	}
+	op := RGAOp{Replica: r.replica, Seq: r.seen[r.replica]+1, ID: RGAID{r.clock+1, r.replica}, Insert: append([]byte(nil), p...)}
+	// after the byte before off, and so in front of any tombstones
+	// after that, since nothing here has a greater id
+	if off > 0 {
+		op.Origin = r.elems[r.at(off-1)].id
+	}
+	r.apply(op)
+	return op, nil
+}
+
+// Delete deletes n bytes at off in the text, and returns the op to send to
+// the other replicas. Like Insert, deleting nothing makes no op.
+func (r *RGA)Delete(off, n int) (RGAOp, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if off < 0 || n < 0 || off+n > r.text.Size() {
+		return RGAOp{}, ErrRange
+	}
+	if n == 0 {
+		return RGAOp{}, nil
+	}
+	op := RGAOp{Replica: r.replica, Seq: r.seen[r.replica]+1}
+	for i := r.at(off); i < len(r.elems) && len(op.Delete) < n; i++ {
+		if !r.elems[i].deleted {
+			op.Delete = append(op.Delete, r.elems[i].id)
+		}
+	}
+	r.apply(op)
+	return op, nil
+}
+
+// Apply applies ops from other replicas, in any order, and returns what
+// they did to the text. Ops that were applied already are skipped, and ops
+// that need ops not yet seen wait for them.
+func (r *RGA)Apply(ops ...RGAOp) (Op, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change := Op{}
+	change.Retain(r.text.Size())
+	for _, op := range ops {
+		if !valid(op) && !op.empty() {
+			return change, ErrRGAOp
+		}
+	}
+	for _, op := range ops {
+		if !op.empty() {
+			r.pending = append(r.pending, op)
+		}
+	}
+	for progress := true; progress; {
+		progress = false
+		waiting := r.pending[:0]
+		for _, op := range r.pending {
+			switch {
+			case op.Seq <= r.seen[op.Replica]:
+			case r.ready(op):
+				var err error
+				if change, err = Compose(change, r.apply(op)); err != nil {
+					panic("web: RGA change does not compose")
+				}
+				progress = true
+			default:
+				waiting = append(waiting, op)
+			}
+		}
+		r.pending = waiting
+	}
+	return change, nil
+}
+
+// empty is whether op is the zero RGAOp, of an edit that did nothing
+func (op RGAOp)empty() bool {
+	return op.Replica == "" && op.Seq == 0 && op.Insert == nil && op.Delete == nil
+}
+
+func valid(op RGAOp) bool {
+	if op.Replica == "" || op.Seq <= 0 || (op.Insert != nil && op.ID.Replica != op.Replica) {
+		return false
+	}
+	for _, id := range op.Delete {
+		if id == (RGAID{}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *RGA)ready(op RGAOp) bool {
+	if op.Seq != r.seen[op.Replica]+1 {
+		return false
+	}
+	if op.Insert != nil {
+		_, ok := r.index(op.Origin)
+		return ok
+	}
+	// every byte to delete is here, found in one pass
+	missing := make(map[RGAID]bool, len(op.Delete))
+	for _, id := range op.Delete {
+		missing[id] = true
+	}
+	for _, e := range r.elems {
+		delete(missing, e.id)
+	}
+	return len(missing) == 0
+}
+
+// apply applies op, which is ready, and returns the change to the text
+func (r *RGA)apply(op RGAOp) Op {
+	size := r.text.Size()
+	r.seen[op.Replica] = op.Seq
+	r.log = append(r.log, op)
+	if len(op.Insert) > 0 {
+		r.clock = max(r.clock, op.ID.Clock+uint64(len(op.Insert))-1)
+		i, _ := r.index(op.Origin)
+		i++
+		for i < len(r.elems) && r.elems[i].id.after(op.ID) {
+			i++
+		}
+		run := make([]rga_elem, len(op.Insert))
+		for j, b := range op.Insert {
+			run[j] = rga_elem{RGAID{op.ID.Clock+uint64(j), op.Replica}, b, false}
+		}
+		r.elems = append(r.elems[:i], append(run, r.elems[i:]...)...)
+		off := r.visible(i)
+		r.text = r.text.Insert(0, off, op.Insert)
+		return ReplaceOp(size, off, 0, op.Insert)
+	}
+
+	gone := make(map[RGAID]bool, len(op.Delete))
+	for _, id := range op.Delete {
+		gone[id] = true
+	}
+	// one pass marks the bytes, and collects their offsets in the text
+	// before the delete, merged into ranges
+	var edits []TextEdit
+	off := 0
+	for i := range r.elems {
+		e := &r.elems[i]
+		if e.deleted {
+			continue
+		}
+		if gone[e.id] {
+			e.deleted = true
+			if l := len(edits); l > 0 && edits[l-1].End == off {
+				edits[l-1].End++
+			} else {
+				edits = append(edits, TextEdit{Start: off, End: off+1})
+			}
+		}
+		off++
+	}
+	for j := len(edits)-1; j >= 0; j-- {
+		r.text = r.text.Delete(0, edits[j].Start, edits[j].End-edits[j].Start)
+	}
+	return EditsOp(size, edits)
+}
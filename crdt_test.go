@@ -0,0 +1,144 @@
+package web
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// rgaOp is the op of a local edit that can not fail
+func rgaOp(op RGAOp, err error) RGAOp {
+	if err != nil {
+		panic(err)
+	}
+	return op
+}
+
+func TestRGAConcurrentInserts(t *testing.T) {
+	a, b := NewRGA("a"), NewRGA("b")
+	base := rgaOp(a.Insert(0, []byte("ac")))
+	b.Apply(base)
+
+	// both insert between a and c, and then keep typing there
+	oa := []RGAOp{rgaOp(a.Insert(1, []byte("xx"))), rgaOp(a.Insert(3, []byte("x")))}
+	ob := []RGAOp{rgaOp(b.Insert(1, []byte("yy"))), rgaOp(b.Insert(1, []byte("Y")))}
+	a.Apply(ob...)
+	b.Apply(oa...)
+	if trieString(a.Text()) != trieString(b.Text()) {
+		t.Fatalf("a has %q, b %q", trieString(a.Text()), trieString(b.Text()))
+	}
+	// the runs of each replica stay together
+	if got := trieString(a.Text()); got != "aYyyxxxc" && got != "axxxYyyc" {
+		t.Fatalf("merged to %q", got)
+	}
+}
+
+func TestRGAOutOfOrder(t *testing.T) {
+	a, b := NewRGA("a"), NewRGA("b")
+	ops := []RGAOp{rgaOp(a.Insert(0, []byte("hello"))), rgaOp(a.Delete(0, 1)), rgaOp(a.Insert(0, []byte("J")))}
+	// the later ops wait for the first
+	change, err := b.Apply(ops[2], ops[1])
+	if err != nil || !change.IsNoop() || b.Text().Size() != 0 {
+		t.Fatalf("Apply of ops that are not ready: %v, %v", change, err)
+	}
+	change, _ = b.Apply(ops[0], ops[0])
+	if trieString(b.Text()) != "Jello" || change.BaseLen() != 0 || change.TargetLen() != 5 {
+		t.Fatalf("b has %q after %v", trieString(b.Text()), change)
+	}
+	if v := b.Version(); v["a"] != 3 {
+		t.Fatalf("Version = %v", v)
+	}
+	if _, err := b.Apply(RGAOp{Replica: "c"}); err != ErrRGAOp {
+		t.Fatalf("Apply of an op without a seq: %v", err)
+	}
+}
+
+// edits past the end are refused, and edits of nothing make no op
+func TestRGARange(t *testing.T) {
+	a, b := NewRGA("a"), NewRGA("b")
+	b.Apply(rgaOp(a.Insert(0, []byte("abc"))))
+	for _, err := range []error{
+		func() error { _, err := a.Insert(4, []byte("x")); return err }(),
+		func() error { _, err := a.Insert(-1, []byte("x")); return err }(),
+		func() error { _, err := a.Delete(2, 2); return err }(),
+		func() error { _, err := a.Delete(0, -1); return err }(),
+	} {
+		if err != ErrRange {
+			t.Fatalf("an edit out of range: %v", err)
+		}
+	}
+	empty := []RGAOp{rgaOp(a.Insert(1, nil)), rgaOp(a.Delete(1, 0))}
+	if change, err := b.Apply(empty...); err != nil || !change.IsNoop() {
+		t.Fatalf("Apply of empty ops: %v, %v", change, err)
+	}
+	// and use up no seq, so the next op goes in
+	if _, err := b.Apply(rgaOp(a.Insert(3, []byte("d")))); err != nil || trieString(b.Text()) != "abcd" {
+		t.Fatalf("b has %q", trieString(b.Text()))
+	}
+	if v := a.Version(); v["a"] != 2 {
+		t.Fatalf("Version = %v", v)
+	}
+}
+
+// a long delete over tombstones shows up in b as the ranges around them
+func TestRGALongDelete(t *testing.T) {
+	a, b := NewRGA("a"), NewRGA("b")
+	b.Apply(rgaOp(a.Insert(0, randSeq(3000))))
+	b.Apply(rgaOp(a.Delete(1000, 100)))
+	b.Apply(rgaOp(a.Delete(1500, 100)))
+	old := b.Text()
+	change, err := b.Apply(rgaOp(a.Delete(500, 2000)))
+	if err != nil || change.IsNoop() {
+		t.Fatalf("Apply of the delete: %v, %v", change, err)
+	}
+	if got := trieString(apply(t, change, old)); got != trieString(b.Text()) {
+		t.Fatalf("the change makes %d bytes, the text is %d", len(got), b.Text().Size())
+	}
+	if trieString(a.Text()) != trieString(b.Text()) || b.Text().Size() != 800 {
+		t.Fatalf("a has %d bytes, b %d", a.Text().Size(), b.Text().Size())
+	}
+}
+
+// replicas edit on their own and merge with each other now and then, and
+// once everyone has merged with everyone they have the same text
+func TestRGAConvergence(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		rs := make([]*RGA, 4)
+		for i := range rs {
+			rs[i] = NewRGA(fmt.Sprint(i))
+		}
+		for i := 0; i < 200; i++ {
+			r := rs[rng.Intn(len(rs))]
+			size := r.Text().Size()
+			switch {
+			case rng.Intn(10) == 0:
+				o := rs[rng.Intn(len(rs))]
+				before := r.Text()
+				change, err := r.Merge(o)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if trieString(apply(t, change, before)) != trieString(r.Text()) {
+					t.Fatalf("seed %d: Merge returned %v, which does not make the text", seed, change)
+				}
+			case size > 0 && rng.Intn(3) == 0:
+				off := rng.Intn(size)
+				rgaOp(r.Delete(off, rng.Intn(size-off)+1))
+			default:
+				rgaOp(r.Insert(rng.Intn(size+1), []byte(trieString(randomText(rng, rng.Intn(4)+1)))))
+			}
+		}
+		for _, r := range rs {
+			for _, o := range rs {
+				r.Merge(o)
+			}
+		}
+		want := trieString(rs[0].Text())
+		for _, r := range rs {
+			if got := trieString(r.Text()); got != want {
+				t.Fatalf("seed %d: replica %s has %q, 0 has %q", seed, r.replica, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+/* Diagnostics report the syntax errors tree-sitter found, the ERROR nodes
+   where it could not make sense of the text and the MISSING ones it made up
+   to carry on, so wiki pages with broken code in them get flagged.
+   Diagnostics follows documents the way the index does: it keeps the errors
+   per top level node, and after an edit the top level nodes the edit did not
+   touch keep theirs, shifted, see unchanged_tops. The code blocks of a page
+   are checked again whole. Every update is reported to the subscribers.
+ */
+package web
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"sync"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+type Diagnostic struct {
+	Range   sitter.Range
+	Message string
+	// Language is the language of the code with the error, which for a code
+	// block is not the language of the page.
+	Language string
+}
+
+// Report is the diagnostics of version Rev of the document at Path.
+type Report struct {
+	Path string
+	Rev  int
+	// Text is the text of the version, nil when the document is gone
+	Text        *Trie[byte]
+	Diagnostics []Diagnostic
+}
+
+// how much of the text of an ERROR node its message quotes
+const quote_max = 24
+
+// syntax_errors walks n for errors. subtrees without errors are skipped, and
+// nothing inside an ERROR is reported again.
+func syntax_errors(lang *Language, text *Trie[byte], n *sitter.Node, out []Diagnostic) []Diagnostic {
+	switch {
+	case n.IsMissing():
+		return append(out, Diagnostic{n.Range(), "missing " + n.Type(), lang.Name})
+	case n.IsError():
+		msg := "syntax error"
+		end := min(n.EndByte(), n.StartByte()+quote_max)
+		if end > n.StartByte() {
+			quoted := textRange(text, int(n.StartByte()), int(end))
+			if i := bytes.IndexByte(quoted, '\n'); i >= 0 {
+				quoted = quoted[:i]
+			}
+			msg = "unexpected " + strconv.Quote(string(quoted))
+		}
+		return append(out, Diagnostic{n.Range(), msg, lang.Name})
+	case !n.HasError():
+		return out
+	}
+	for _, c := range children(n) {
+		out = syntax_errors(lang, text, c, out)
+	}
+	return out
+}
+
+// SyntaxErrors returns the syntax errors of v in lang, and of the injections
+// in it in the languages of reg, which may be nil to skip them.
+func SyntaxErrors(reg *Registry, lang *Language, v *Version) []Diagnostic {
+	if lang == nil || v.Tree == nil {
+		return nil
+	}
+	tree := v.Tree.Copy()
+	out := syntax_errors(lang, v.Text, tree.RootNode(), nil)
+	if reg != nil {
+		for _, inj := range reg.Injections(lang, v) {
+			out = syntax_errors(inj.Language, v.Text, inj.Tree.RootNode(), out)
+		}
+	}
+	sort_diagnostics(out)
+	return out
+}
+
+func sort_diagnostics(ds []Diagnostic) {
+	sort.SliceStable(ds, func(i, j int) bool {
+		return ds[i].Range.StartByte < ds[j].Range.StartByte
+	})
+}
+
+type diagnosed_file struct {
+	lang *Language
+	rev  int
+	text *Trie[byte]
+	tree *sitter.Tree // our own copy
+	tops [][]Diagnostic
+	all  []Diagnostic
+}
+
+type Diagnostics struct {
+	reg *Registry
+	// update keeps updates, and so reports, in order. mu guards the rest
+	update sync.Mutex
+	mu     sync.Mutex
+	files  map[string]*diagnosed_file
+	subs   map[int]func(Report)
+	next   int
+}
+
+// NewDiagnostics checks injections with the languages in reg, which may be
+// nil to skip them.
+func NewDiagnostics(reg *Registry) *Diagnostics {
+	return &Diagnostics{reg: reg, files: map[string]*diagnosed_file{}, subs: map[int]func(Report){}}
+}
+
+// Update checks v, the latest version of the document at path, and reports
+// it to the subscribers.
+func (ds *Diagnostics)Update(path string, lang *Language, v *Version) Report {
+	ds.update.Lock()
+	defer ds.update.Unlock()
+	if lang == nil || v.Tree == nil {
+		ds.mu.Lock()
+		delete(ds.files, path)
+		ds.mu.Unlock()
+		return ds.publish(Report{Path: path, Rev: v.Rev, Text: v.Text})
+	}
+
+	ds.mu.Lock()
+	old := ds.files[path]
+	ds.mu.Unlock()
+
+	f := &diagnosed_file{lang: lang, rev: v.Rev, text: v.Text, tree: v.Tree.Copy()}
+	reuse := map[top_level_key][]Diagnostic{}
+	if old != nil && old.lang == lang && old.rev == v.Rev-1 && v.Edit != nil {
+		for key, i := range unchanged_tops(old.tree, *v.Edit) {
+			shifted := make([]Diagnostic, len(old.tops[i]))
+			for j, d := range old.tops[i] {
+				d.Range = shift_range(d.Range, *v.Edit)
+				shifted[j] = d
+			}
+			reuse[key] = shifted
+		}
+	}
+	root := f.tree.RootNode()
+	for _, c := range children(root) {
+		d, ok := reuse[key_of(c)]
+		if !ok {
+			d = syntax_errors(lang, v.Text, c, nil)
+		}
+		f.tops = append(f.tops, d)
+		f.all = append(f.all, d...)
+	}
+	// when nothing parsed the root is the one error, but the tops are kept
+	// for the next version
+	if root.IsError() {
+		f.all = syntax_errors(lang, v.Text, root, nil)
+	}
+	if ds.reg != nil {
+		for _, inj := range ds.reg.Injections(lang, v) {
+			f.all = syntax_errors(inj.Language, v.Text, inj.Tree.RootNode(), f.all)
+		}
+	}
+	sort_diagnostics(f.all)
+
+	ds.mu.Lock()
+	ds.files[path] = f
+	ds.mu.Unlock()
+	return ds.publish(Report{path, v.Rev, v.Text, f.all})
+}
+
+func (ds *Diagnostics)publish(r Report) Report {
+	ds.mu.Lock()
+	subs := make([]func(Report), 0, len(ds.subs))
+	for _, f := range ds.subs {
+		subs = append(subs, f)
+	}
+	ds.mu.Unlock()
+	for _, f := range subs {
+		f(r)
+	}
+	return r
+}
+
+// Get returns the latest report for path, with no diagnostics and a Rev of
+// -1 if there is none.
+func (ds *Diagnostics)Get(path string) Report {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	f := ds.files[path]
+	if f == nil {
+		return Report{Path: path, Rev: -1}
+	}
+	return Report{path, f.rev, f.text, f.all}
+}
+
+// Remove forgets path, and reports it as without errors.
+func (ds *Diagnostics)Remove(path string) {
+	ds.update.Lock()
+	defer ds.update.Unlock()
+	ds.mu.Lock()
+	f := ds.files[path]
+	delete(ds.files, path)
+	ds.mu.Unlock()
+	if f != nil {
+		ds.publish(Report{Path: path, Rev: f.rev})
+	}
+}
+
+// Subscribe calls f with every report from now on, in order, until the
+// returned cancel is called. f runs on the goroutine that made the update
+// and must not make another.
+func (ds *Diagnostics)Subscribe(f func(Report)) (cancel func()) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	id := ds.next
+	ds.next++
+	ds.subs[id] = f
+	return func() {
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+		delete(ds.subs, id)
+	}
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSyntaxErrors(t *testing.T) {
+	r := DefaultRegistry()
+	src := "package main\n\nfunc main() {\n\tx := (1 + \n}\n\nfunc ok() {}\n"
+	d, l := r.Open("main.go", []byte(src))
+	ds := SyntaxErrors(r, l, d.Current())
+	if len(ds) == 0 {
+		t.Fatalf("no errors in %q", src)
+	}
+	for _, e := range ds {
+		if e.Language != "go" || e.Range.StartPoint.Row < 3 || e.Range.StartPoint.Row > 4 {
+			t.Errorf("error %v is not on lines 4 and 5", e)
+		}
+	}
+
+	d, l = r.Open("ok.go", []byte("package main\n\nfunc main() {}\n"))
+	if ds := SyntaxErrors(r, l, d.Current()); ds != nil {
+		t.Fatalf("errors in a good file: %v", ds)
+	}
+
+	page := "# Broken\n\n```go\nfunc f( {\n```\n\n```py\nprint(1)\n```\n"
+	d, md := r.Open("page.md", []byte(page))
+	ds = SyntaxErrors(r, md, d.Current())
+	if len(ds) == 0 {
+		t.Fatalf("no errors in the page's go block")
+	}
+	for _, e := range ds {
+		if e.Language != "go" || e.Range.StartPoint.Row != 3 {
+			t.Errorf("error %v is not in the go block", e)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	r := DefaultRegistry()
+	ds := NewDiagnostics(r)
+	var reports []Report
+	cancel := ds.Subscribe(func(rep Report) { reports = append(reports, rep) })
+
+	src := strings.Repeat("func f() {}\n", 10)
+	l := r.Lookup("go")
+	d := NewDocument(l.Grammar, []byte("package main\n"+src))
+	ds.Update("a.go", l, d.Current())
+	if got := ds.Get("a.go"); got.Rev != 0 || got.Diagnostics != nil {
+		t.Fatalf("Get = %+v", got)
+	}
+
+	v, _ := d.Insert(len("package main\n")+5, []byte("("))
+	ds.Update("a.go", l, v)
+	if got := ds.Get("a.go"); got.Rev != 1 || len(got.Diagnostics) == 0 {
+		t.Fatalf("no errors after breaking f: %+v", got)
+	}
+	v, _ = d.Delete(len("package main\n")+5, 1)
+	ds.Update("a.go", l, v)
+	if got := ds.Get("a.go"); got.Rev != 2 || got.Diagnostics != nil {
+		t.Fatalf("errors after fixing f: %+v", got)
+	}
+
+	ds.Remove("a.go")
+	cancel()
+	ds.Update("a.go", l, v)
+	revs := []int{}
+	for _, rep := range reports {
+		revs = append(revs, rep.Rev)
+	}
+	if !reflect.DeepEqual(revs, []int{0, 1, 2, 2}) || reports[3].Diagnostics != nil {
+		t.Fatalf("reports for revisions %v", revs)
+	}
+	if got := ds.Get("nope.go"); got.Rev != -1 {
+		t.Fatalf("Get of a missing document: %+v", got)
+	}
+}
+
+func TestDiagnosticsIncremental(t *testing.T) {
+	r := DefaultRegistry()
+	ds := NewDiagnostics(r)
+	l := r.Lookup("go")
+	d := NewDocument(l.Grammar, []byte(strings.Repeat(go_source, 5)))
+	ds.Update("a.go", l, d.Current())
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		size := d.Current().Text.Size()
+		off := rng.Intn(size)
+		var v *Version
+		if rng.Intn(2) == 0 {
+			v, _ = d.Delete(off, min(rng.Intn(4), size-off))
+		} else {
+			v, _ = d.Insert(off, []byte([]string{"(", "}", "{", "func", "\n", "x"}[rng.Intn(6)]))
+		}
+		got := ds.Update("a.go", l, v).Diagnostics
+		if want := SyntaxErrors(r, l, v); !reflect.DeepEqual(got, want) {
+			t.Fatalf("edit %d: incremental diagnostics\n%v\nwant\n%v", i, got, want)
+		}
+	}
+}
@@ -1,5 +1,11 @@
 module web
 
-go 1.22.2
+go 1.23
 
-require github.com/smacker/go-tree-sitter v0.0.0-20240827094217-dd81d9e9be82 // indirect
+require (
+	github.com/mattn/go-sqlite3 v1.14.52
+	github.com/smacker/go-tree-sitter v0.0.0-20240827094217-dd81d9e9be82
+	go.etcd.io/bbolt v1.4.0
+)
+
+require golang.org/x/sys v0.29.0 // indirect
@@ -0,0 +1,180 @@
+/* Highlights are worked out on the server from the highlight query of a
+   document's language, so the wiki's frontend can colour code without
+   shipping a parser.
+   A Highlighter follows one document. It only queries the top level nodes
+   that overlap the range it is asked for, and keeps what it found per top
+   level node, so scrolling through a document queries each part of it once.
+   After an edit the top level nodes the edit did not touch keep their
+   highlights, shifted, see unchanged_tops, and only the rest is queried
+   again when it is next visible.
+ */
+package web
+
+import (
+	"sort"
+	"sync"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// Capture is what the highlight query captured the text as, "keyword",
+	// "string", "function.call" and so on.
+	Capture string `json:"capture"`
+}
+
+type highlighted struct {
+	done  bool
+	spans []Highlight
+}
+
+type Highlighter struct {
+	lang *Language
+	reg  *Registry
+	mu   sync.Mutex
+	rev  int
+	// our own copy of the tree of rev, and what we know of each of its top
+	// level nodes
+	tree *sitter.Tree
+	tops []highlighted
+	// the highlights of the injections in rev, code blocks are small so they
+	// are done whole, once per version
+	injected      []Highlight
+	injected_done bool
+	queried       int // top level nodes queried since rev came in
+}
+
+// NewHighlighter makes a highlighter for a document in lang, which
+// highlights injections with the languages in reg. reg may be nil.
+func NewHighlighter(lang *Language, reg *Registry) *Highlighter {
+	return &Highlighter{lang: lang, reg: reg}
+}
+
+// highlights runs the highlight query of lang over n
+func highlights(lang *Language, n *sitter.Node) []Highlight {
+	q := lang.query(lang.Highlights)
+	if q == nil {
+		return nil
+	}
+	qc := sitter.NewQueryCursor()
+	defer qc.Close()
+	qc.Exec(q, n)
+
+	type capture struct {
+		Highlight
+		pattern uint16
+	}
+	var all []capture
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		for _, c := range m.Captures {
+			h := Highlight{int(c.Node.StartByte()), int(c.Node.EndByte()), q.CaptureNameForId(c.Index)}
+			if h.Start < h.End {
+				all = append(all, capture{h, m.PatternIndex})
+			}
+		}
+	}
+	// outer captures first, and the first pattern first for the same node
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Start != all[j].Start {
+			return all[i].Start < all[j].Start
+		}
+		if all[i].End != all[j].End {
+			return all[i].End > all[j].End
+		}
+		return all[i].pattern < all[j].pattern
+	})
+	var out []Highlight
+	end := 0
+	for _, c := range all {
+		if c.Start < end {
+			continue
+		}
+		out = append(out, c.Highlight)
+		end = c.End
+	}
+	return out
+}
+
+// follow moves the highlighter to v
+func (h *Highlighter)follow(v *Version) {
+	if h.tree != nil && h.rev == v.Rev {
+		return
+	}
+	tree := v.Tree.Copy()
+	top := children(tree.RootNode())
+	tops := make([]highlighted, len(top))
+	if h.tree != nil && v.Rev == h.rev+1 && v.Edit != nil {
+		reuse := unchanged_tops(h.tree, *v.Edit)
+		for i, c := range top {
+			j, ok := reuse[key_of(c)]
+			if !ok || !h.tops[j].done {
+				continue
+			}
+			spans := make([]Highlight, len(h.tops[j].spans))
+			for k, s := range h.tops[j].spans {
+				spans[k] = Highlight{
+					Start:   int(shift_index(uint32(s.Start), *v.Edit)),
+					End:     int(shift_index(uint32(s.End), *v.Edit)),
+					Capture: s.Capture,
+				}
+			}
+			tops[i] = highlighted{true, spans}
+		}
+	}
+	h.rev, h.tree, h.tops = v.Rev, tree, tops
+	h.injected, h.injected_done = nil, false
+	h.queried = 0
+}
+
+// Highlights returns the highlights of v that overlap start to end, clipped
+// to it, in order and without overlaps. Only the version after the one asked
+// for last can reuse anything, so v should be the document's latest.
+func (h *Highlighter)Highlights(v *Version, start, end int) []Highlight {
+	if h.lang == nil || v.Tree == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.follow(v)
+
+	var all []Highlight
+	for i, c := range children(h.tree.RootNode()) {
+		if int(c.EndByte()) <= start {
+			continue
+		}
+		if int(c.StartByte()) >= end {
+			break
+		}
+		if !h.tops[i].done {
+			h.tops[i] = highlighted{true, highlights(h.lang, c)}
+			h.queried++
+		}
+		all = append(all, h.tops[i].spans...)
+	}
+	if h.reg != nil && !h.injected_done {
+		for _, inj := range h.reg.Injections(h.lang, v) {
+			h.injected = append(h.injected, highlights(inj.Language, inj.Tree.RootNode())...)
+		}
+		h.injected_done = true
+	}
+	all = append(all, h.injected...)
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Start < all[j].Start
+	})
+
+	var out []Highlight
+	for _, s := range all {
+		if s.End <= start || s.Start >= end {
+			continue
+		}
+		s.Start, s.End = max(s.Start, start), min(s.End, end)
+		out = append(out, s)
+	}
+	return out
+}
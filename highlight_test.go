@@ -0,0 +1,113 @@
+package web
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHighlightQueries(t *testing.T) {
+	for _, l := range builtin_languages() {
+		if l.Highlights != "" && l.query(l.Highlights) == nil {
+			t.Errorf("%s: no highlight query", l.Name)
+		}
+	}
+}
+
+// spans renders highlights as text:capture
+func spans(text string, hs []Highlight) []string {
+	var out []string
+	for _, h := range hs {
+		out = append(out, text[h.Start:h.End]+":"+h.Capture)
+	}
+	return out
+}
+
+func TestHighlights(t *testing.T) {
+	r := DefaultRegistry()
+	d, l := r.Open("main.go", []byte(go_source))
+	h := NewHighlighter(l, r)
+	at := strings.Index(go_source, "func main")
+	got := spans(go_source, h.Highlights(d.Current(), at, len(go_source)))
+	want := []string{"func:keyword", "main:function", "println:function.call"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Highlights = %v, want %v", got, want)
+	}
+	queried := h.queried
+
+	// the range clips, and what was looked at already is not again
+	got = spans(go_source, h.Highlights(d.Current(), at+1, at+3))
+	if !reflect.DeepEqual(got, []string{"un:keyword"}) {
+		t.Fatalf("clipped Highlights = %v", got)
+	}
+	if h.queried != queried {
+		t.Fatalf("queried main again")
+	}
+
+	got = spans(go_source, h.Highlights(d.Current(), 0, len(go_source)))
+	for _, w := range []string{"package:keyword", "Point:type", "0:number", "Add:function.method"} {
+		found := false
+		for _, g := range got {
+			found = found || g == w
+		}
+		if !found {
+			t.Errorf("no %s in %v", w, got)
+		}
+	}
+	hs := h.Highlights(d.Current(), 0, len(go_source))
+	for i := 1; i < len(hs); i++ {
+		if hs[i].Start < hs[i-1].End {
+			t.Fatalf("%v overlaps %v", hs[i], hs[i-1])
+		}
+	}
+}
+
+func TestHighlightsInjected(t *testing.T) {
+	r := DefaultRegistry()
+	d, md := r.Open("page.md", []byte(wiki_page))
+	h := NewHighlighter(md, r)
+	got := spans(wiki_page, h.Highlights(d.Current(), 0, len(wiki_page)))
+	want := []string{"# Notes\n:markup.heading", "```:punctuation", "go:label",
+		"func:keyword", "main:function", "```:punctuation"}
+	if !reflect.DeepEqual(got[:len(want)], want) {
+		t.Fatalf("Highlights = %v, want %v...", got, want)
+	}
+	if !reflect.DeepEqual(got[len(want):len(want)+4], []string{"```:punctuation", "py:label", "def:keyword", "f:function"}) {
+		t.Fatalf("python block: %v", got[len(want):])
+	}
+}
+
+func TestHighlightsIncremental(t *testing.T) {
+	r := DefaultRegistry()
+	l := r.Lookup("go")
+	d := NewDocument(l.Grammar, []byte(strings.Repeat(go_source, 20)))
+	h := NewHighlighter(l, r)
+	h.Highlights(d.Current(), 0, d.Current().Text.Size())
+
+	v, _ := d.Insert(strings.Index(go_source, "println"), []byte("x := 1; "))
+	h.Highlights(v, 0, v.Text.Size())
+	if h.queried > 2 {
+		t.Fatalf("queried %d top level nodes for one edit", h.queried)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		size := d.Current().Text.Size()
+		off := rng.Intn(size)
+		if rng.Intn(2) == 0 {
+			v, _ = d.Delete(off, min(rng.Intn(8), size-off))
+		} else {
+			v, _ = d.Insert(off, []byte([]string{"func g() {}\n", "}", "\"", "/*", "x"}[rng.Intn(5)]))
+		}
+		size = v.Text.Size()
+		start := rng.Intn(size)
+		end := start + rng.Intn(size-start+1)
+
+		got := h.Highlights(v, start, end)
+		want := NewHighlighter(l, r).Highlights(&Version{Text: v.Text, Tree: v.Tree}, start, end)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("edit %d: incremental highlights\n%v\nwant\n%v", i, got, want)
+		}
+	}
+}
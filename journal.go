@@ -0,0 +1,317 @@
+/* A Journal keeps a document in a directory, as a snapshot file and an op
+   log, so it can be opened without replaying everything ever typed.
+   Every SnapshotEvery revisions the journal is compacted. The snapshot file
+   is written again with two versions, the current one and the oldest one
+   kept, Retain revisions back. The revisions between them go into a new
+   log segment, which the current one is replaced by. Both versions of the
+   snapshot share their nodes, and the segment holds just the history kept,
+   so undo and audit can go back as far as that and the journal stays the
+   same size however long the document has been edited.
+   The files of a compaction are numbered with its generation. The log goes
+   first, then the snapshot, which is written to a temporary file and
+   renamed into place, so it is there whole or not at all. Opening takes the
+   latest snapshot and its log, and removes whatever else is left. A
+   snapshot that is there but can't be read is an error: the files are left
+   as they are for someone to look at, rather than taken for a fresh start.
+   A compaction that fails is tried again on the next revision; the
+   revision itself is logged already, so Append doesn't fail for it.
+ */
+package web
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type JournalOptions struct {
+	Log LogOptions
+	// SnapshotEvery is how many revisions are logged between compactions,
+	// 0 for only when Compact is called
+	SnapshotEvery int
+	// Retain is how many revisions of history are kept
+	Retain int
+}
+
+type Journal struct {
+	dir  string
+	opts JournalOptions
+	mu   sync.Mutex
+	gen  int
+	log  *OpLog
+	// the oldest version kept, the current one, and the one last snapshot
+	base, head Snapshot
+	snapped    int
+	err        error // the last compaction of Append, if it failed
+}
+
+func (j *Journal)file(gen int, ext string) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%08d.%s", gen, ext))
+}
+
+// OpenJournal opens the journal in dir, or starts an empty document there.
+func OpenJournal(dir string, opts JournalOptions) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	j := &Journal{dir: dir, opts: opts}
+	gens, err := j.generations()
+	if err != nil {
+		return nil, err
+	}
+	var snaps []Snapshot
+	if len(gens) > 0 {
+		j.gen = gens[len(gens)-1]
+		if snaps, err = j.read_snapshot(j.gen); err != nil {
+			return nil, fmt.Errorf("%s: %w", j.file(j.gen, "snap"), err)
+		}
+	} else {
+		snaps = []Snapshot{{0, NewTrie[byte](0)}}
+		if err := j.write_snapshot(j.gen, snaps); err != nil {
+			return nil, err
+		}
+	}
+	j.base, j.head = snaps[0], snaps[len(snaps)-1]
+	j.snapped = j.head.Rev
+
+	if j.log, err = OpenOpLog(j.file(j.gen, "log"), opts.Log); err != nil {
+		return nil, err
+	}
+	if j.head.Text, j.head.Rev, err = j.log.Replay(j.head.Text, j.head.Rev); err != nil {
+		j.log.Close()
+		return nil, err
+	}
+	if err := j.remove_others(); err != nil {
+		j.log.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// generations returns the generations with a snapshot in the directory, in
+// order
+func (j *Journal)generations() ([]int, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+	var gens []int
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".snap")
+		if gen, err := strconv.Atoi(name); ok && err == nil {
+			gens = append(gens, gen)
+		}
+	}
+	sort.Ints(gens)
+	return gens, nil
+}
+
+func (j *Journal)read_snapshot(gen int) ([]Snapshot, error) {
+	f, err := os.Open(j.file(gen, "snap"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	snaps, err := ReadSnapshots(f)
+	if err == nil && len(snaps) == 0 {
+		return nil, ErrSnapshotFormat
+	}
+	return snaps, err
+}
+
+func (j *Journal)write_snapshot(gen int, snaps []Snapshot) error {
+	tmp := j.file(gen, "snap.tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = WriteSnapshots(f, snaps)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.file(gen, "snap"))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return sync_dir(j.dir)
+}
+
+func sync_dir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// remove_others removes the files of every generation but the current one
+func (j *Journal)remove_others() error {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return err
+	}
+	keep := map[string]bool{filepath.Base(j.file(j.gen, "snap")): true, filepath.Base(j.file(j.gen, "log")): true}
+	for _, e := range entries {
+		if !keep[e.Name()] && (strings.HasSuffix(e.Name(), ".snap") || strings.HasSuffix(e.Name(), ".log") || strings.HasSuffix(e.Name(), ".tmp")) {
+			if err := os.Remove(filepath.Join(j.dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Text returns the current text and its revision.
+func (j *Journal)Text() (*Trie[byte], int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.head.Text, j.head.Rev
+}
+
+// Oldest returns the oldest revision that is kept.
+func (j *Journal)Oldest() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.base.Rev
+}
+
+// Append logs r, which must be the next revision, and compacts the journal
+// when it is time to. Once r is logged Append succeeds; a compaction that
+// fails is kept for Close to report.
+func (j *Journal)Append(r Revision) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if r.Rev != j.head.Rev+1 {
+		return ErrRevision
+	}
+	text, err := r.Op.Apply(j.head.Text)
+	if err != nil {
+		return err
+	}
+	if err := j.log.Append(r); err != nil {
+		return err
+	}
+	j.head = Snapshot{r.Rev, text}
+	if j.opts.SnapshotEvery > 0 && j.head.Rev-j.snapped >= j.opts.SnapshotEvery {
+		j.err = j.compact()
+	}
+	return nil
+}
+
+// History returns the revisions after rev, which must be kept.
+func (j *Journal)History(rev int) ([]Revision, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.history(rev)
+}
+
+func (j *Journal)history(rev int) ([]Revision, error) {
+	if rev < j.base.Rev || rev > j.head.Rev {
+		return nil, ErrRevision
+	}
+	var out []Revision
+	err := j.log.each(func(r Revision) error {
+		if r.Rev > rev {
+			out = append(out, r)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// At returns the text at rev, which must be kept.
+func (j *Journal)At(rev int) (*Trie[byte], error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.at(rev)
+}
+
+func (j *Journal)at(rev int) (*Trie[byte], error) {
+	if rev < j.base.Rev || rev > j.head.Rev {
+		return nil, ErrRevision
+	}
+	text := j.base.Text
+	err := j.log.each(func(r Revision) error {
+		if r.Rev <= j.base.Rev || r.Rev > rev {
+			return nil
+		}
+		var err error
+		text, err = r.Op.Apply(text)
+		return err
+	})
+	return text, err
+}
+
+// Compact writes a snapshot of the current text and drops the history past
+// the retention window.
+func (j *Journal)Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.compact()
+}
+
+func (j *Journal)compact() error {
+	base := Snapshot{Rev: max(j.base.Rev, j.head.Rev-j.opts.Retain)}
+	var err error
+	if base.Text, err = j.at(base.Rev); err != nil {
+		return err
+	}
+	kept, err := j.history(base.Rev)
+	if err != nil {
+		return err
+	}
+
+	gen := j.gen+1
+	os.Remove(j.file(gen, "log"))
+	log, err := OpenOpLog(j.file(gen, "log"), LogOptions{Sync: SyncNever})
+	if err != nil {
+		return err
+	}
+	for _, r := range kept {
+		if err = log.Append(r); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = log.Sync()
+	}
+	log.Close()
+	if err == nil {
+		log, err = OpenOpLog(j.file(gen, "log"), j.opts.Log)
+	}
+	if err != nil {
+		os.Remove(j.file(gen, "log"))
+		return err
+	}
+	if err := j.write_snapshot(gen, []Snapshot{base, j.head}); err != nil {
+		log.Close()
+		os.Remove(j.file(gen, "log"))
+		return err
+	}
+	j.log.Close()
+	j.log, j.gen, j.base, j.snapped, j.err = log, gen, base, j.head.Rev, nil
+	return j.remove_others()
+}
+
+// Close closes the log. The journal opens where it left off. It returns the
+// error of the last compaction too, if that failed and none has worked
+// since.
+func (j *Journal)Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.log.Close(); err != nil {
+		return err
+	}
+	return j.err
+}
@@ -0,0 +1,157 @@
+package web
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	opts := JournalOptions{SnapshotEvery: 20, Retain: 15, Log: LogOptions{Sync: SyncNever}}
+	j, err := OpenJournal(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSession(nil)
+	s.Subscribe(func(r Revision) {
+		if err := j.Append(r); err != nil {
+			t.Error(err)
+		}
+	})
+	rng := rand.New(rand.NewSource(1))
+	texts := []string{""}
+	for i := 0; i < 50; i++ {
+		text, rev := s.Snapshot()
+		s.Receive("c", rev, randomOp(rng, text.Size()))
+		text, _ = s.Snapshot()
+		texts = append(texts, trieString(text))
+	}
+
+	// compacted at 40, keeping from 25 on
+	if j.Oldest() != 25 {
+		t.Fatalf("Oldest() = %d", j.Oldest())
+	}
+	for _, rev := range []int{25, 33, 50} {
+		if text, err := j.At(rev); err != nil || trieString(text) != texts[rev] {
+			t.Fatalf("At(%d) = %q, %v", rev, trieString(text), err)
+		}
+	}
+	if _, err := j.At(24); err != ErrRevision {
+		t.Fatalf("At of a revision that was dropped: %v", err)
+	}
+	if h, _ := j.History(45); len(h) != 5 || h[0].Rev != 46 {
+		t.Fatalf("History(45) = %v", h)
+	}
+	j.Close()
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 2 {
+		t.Fatalf("%d files left after compaction", len(files))
+	}
+	j, err = OpenJournal(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, rev := j.Text()
+	if rev != 50 || trieString(text) != texts[50] || j.Oldest() != 25 {
+		t.Fatalf("reopened at %d from %d", rev, j.Oldest())
+	}
+
+	// a session picks up where the journal left off
+	s = NewSessionAt(text, rev)
+	s.Subscribe(func(r Revision) { j.Append(r) })
+	s.Receive("c", 50, ReplaceOp(text.Size(), 0, 0, []byte("!")))
+	if text, rev := j.Text(); rev != 51 || trieString(text) != "!"+texts[50] {
+		t.Fatalf("after reopening appended to %d", rev)
+	}
+	j.Close()
+}
+
+// a compaction that died before its snapshot was in place leaves the last
+// generation as it was
+func TestJournalCrashedCompaction(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := OpenJournal(dir, JournalOptions{})
+	j.Append(Revision{1, "c", ReplaceOp(0, 0, 0, []byte("hello"))})
+	j.Close()
+	os.WriteFile(filepath.Join(dir, "00000001.log"), []byte("half"), 0o644)
+	os.WriteFile(filepath.Join(dir, "00000001.snap.tmp"), []byte("half"), 0o644)
+
+	j, err := OpenJournal(dir, JournalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if text, rev := j.Text(); rev != 1 || trieString(text) != "hello" {
+		t.Fatalf("opened at %d, %q", rev, trieString(text))
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Fatalf("%d files left", len(files))
+	}
+}
+
+// a snapshot that can't be read stops the journal from opening, and nothing
+// is removed
+func TestJournalBadSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := OpenJournal(dir, JournalOptions{})
+	j.Append(Revision{1, "c", ReplaceOp(0, 0, 0, []byte("hello"))})
+	j.Compact()
+	j.Append(Revision{2, "c", ReplaceOp(5, 0, 0, []byte("!"))})
+	j.Close()
+	snap := filepath.Join(dir, "00000001.snap")
+	p, _ := os.ReadFile(snap)
+	os.WriteFile(snap, p[:len(p)/2], 0o644)
+
+	if _, err := OpenJournal(dir, JournalOptions{}); err == nil {
+		t.Fatal("opened a journal with a torn snapshot")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Fatalf("%d files left, want the 2 of the last generation", len(files))
+	}
+}
+
+// a compaction that fails doesn't fail the revision that set it off, which
+// is logged, and is tried again on the next one
+func TestJournalFailedCompaction(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := OpenJournal(dir, JournalOptions{SnapshotEvery: 2, Retain: 10})
+	// the log of the next generation can't be made where a directory is
+	blocker := filepath.Join(dir, "00000001.log")
+	os.MkdirAll(filepath.Join(blocker, "x"), 0o755)
+	text := ""
+	for rev := 1; rev <= 3; rev++ {
+		if err := j.Append(Revision{rev, "c", ReplaceOp(len(text), 0, 0, []byte("ab"))}); err != nil {
+			t.Fatalf("Append %d: %v", rev, err)
+		}
+		text += "ab"
+	}
+	if err := j.Compact(); err == nil {
+		t.Fatal("Compact worked with its log in the way")
+	}
+	os.RemoveAll(blocker)
+	if err := j.Append(Revision{4, "c", ReplaceOp(len(text), 0, 0, []byte("ab"))}); err != nil {
+		t.Fatal(err)
+	}
+	text += "ab"
+	if _, err := os.Stat(filepath.Join(dir, "00000001.snap")); err != nil {
+		t.Fatalf("no compaction after the log was out of the way: %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close after a compaction worked: %v", err)
+	}
+
+	j, err := OpenJournal(dir, JournalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if got, rev := j.Text(); rev != 4 || trieString(got) != text {
+		t.Fatalf("opened at %d, %q", rev, trieString(got))
+	}
+	if j.Oldest() != 0 {
+		t.Fatalf("Oldest = %d", j.Oldest())
+	}
+}
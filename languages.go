@@ -0,0 +1,370 @@
+/* The grammar registry maps documents to tree-sitter languages.
+   A document's language is decided, in order, by:
+   + a vim or emacs modeline in the first or last few lines
+   + a #! line naming an interpreter
+   + its file name, for files like Dockerfile
+   + its extension
+   A language can also have an injection query, which finds text in a
+   document that is written in another language, such as the code blocks in
+   a Markdown wiki page. Injections are parsed on their own with the
+   included ranges set, so their nodes have offsets into the host document.
+ */
+package web
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/bash"
+	"github.com/smacker/go-tree-sitter/c"
+	"github.com/smacker/go-tree-sitter/cpp"
+	"github.com/smacker/go-tree-sitter/css"
+	"github.com/smacker/go-tree-sitter/dockerfile"
+	"github.com/smacker/go-tree-sitter/golang"
+	"github.com/smacker/go-tree-sitter/html"
+	"github.com/smacker/go-tree-sitter/java"
+	"github.com/smacker/go-tree-sitter/javascript"
+	"github.com/smacker/go-tree-sitter/lua"
+	markdown "github.com/smacker/go-tree-sitter/markdown/tree-sitter-markdown"
+	"github.com/smacker/go-tree-sitter/python"
+	"github.com/smacker/go-tree-sitter/ruby"
+	"github.com/smacker/go-tree-sitter/rust"
+	"github.com/smacker/go-tree-sitter/sql"
+	"github.com/smacker/go-tree-sitter/toml"
+	"github.com/smacker/go-tree-sitter/typescript/tsx"
+	"github.com/smacker/go-tree-sitter/typescript/typescript"
+	"github.com/smacker/go-tree-sitter/yaml"
+)
+
+type Language struct {
+	Name    string
+	Grammar *sitter.Language
+	// Extensions include the dot, ".go". Filenames are matched whole.
+	Extensions []string
+	Filenames  []string
+	// Interpreters are the programs a #! line can name, "python3".
+	Interpreters []string
+	// Aliases are other names for the language, as used by modelines and
+	// Markdown code blocks, "py".
+	Aliases []string
+	// Injections is a query capturing @injection.content. The language of
+	// the content is either captured as @injection.language or given with
+	// (#set! injection.language "name").
+	Injections string
+	// Tags is a query capturing the @name of every definition, along with
+	// the whole of it as @definition.<kind>, e.g. @definition.function.
+	// When two patterns capture the same definition the later one wins.
+	// Identifiers captured as @reference are uses of the name they spell.
+	Tags string
+	// Highlights is a query whose capture names, @keyword, @string and so on,
+	// are what the text they capture is highlighted as.
+	Highlights string
+	// Folds is a query capturing the nodes that fold as @fold, or as
+	// @fold.<kind>.
+	Folds string
+
+	mu       sync.Mutex
+	compiled map[string]*sitter.Query
+}
+
+// query compiles src once, and returns nil when src is empty. Our queries
+// are fixed, so a bad one is a bug and panics.
+func (l *Language)query(src string) *sitter.Query {
+	if src == "" {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if q, ok := l.compiled[src]; ok {
+		return q
+	}
+	q, err := sitter.NewQuery([]byte(src), l.Grammar)
+	if err != nil {
+		panic("bad query for " + l.Name + ": " + err.Error())
+	}
+	if l.compiled == nil {
+		l.compiled = map[string]*sitter.Query{}
+	}
+	l.compiled[src] = q
+	return q
+}
+
+func builtin_languages() []*Language {
+	return []*Language{
+		{Name: "go", Grammar: golang.GetLanguage(),
+			Extensions: []string{".go"}, Aliases: []string{"golang"},
+			Tags: go_tags, Highlights: go_highlights,
+			Folds: go_folds},
+		{Name: "python", Grammar: python.GetLanguage(),
+			Extensions: []string{".py", ".pyw"}, Aliases: []string{"py", "python3"},
+			Interpreters: []string{"python", "python2", "python3"},
+			Tags: python_tags, Highlights: python_highlights,
+			Folds: python_folds},
+		{Name: "rust", Grammar: rust.GetLanguage(),
+			Extensions: []string{".rs"}, Aliases: []string{"rs"},
+			Tags: rust_tags, Highlights: rust_highlights,
+			Folds: rust_folds},
+		{Name: "javascript", Grammar: javascript.GetLanguage(),
+			Extensions: []string{".js", ".mjs", ".cjs", ".jsx"}, Aliases: []string{"js", "jsx"},
+			Interpreters: []string{"node", "nodejs"},
+			Tags: javascript_tags, Highlights: javascript_highlights,
+			Folds: javascript_folds},
+		{Name: "typescript", Grammar: typescript.GetLanguage(),
+			Extensions: []string{".ts", ".mts", ".cts"}, Aliases: []string{"ts"},
+			Interpreters: []string{"ts-node", "deno"},
+			Tags: typescript_tags, Highlights: typescript_highlights,
+			Folds: javascript_folds},
+		{Name: "tsx", Grammar: tsx.GetLanguage(), Extensions: []string{".tsx"},
+			Tags: typescript_tags, Highlights: typescript_highlights,
+			Folds: javascript_folds},
+		{Name: "markdown", Grammar: markdown.GetLanguage(),
+			Extensions: []string{".md", ".markdown"}, Aliases: []string{"md"},
+			Injections: markdown_injections,
+			Tags: markdown_tags, Highlights: markdown_highlights,
+			Folds: markdown_folds},
+		{Name: "yaml", Grammar: yaml.GetLanguage(),
+			Extensions: []string{".yaml", ".yml"}, Aliases: []string{"yml"}},
+		{Name: "toml", Grammar: toml.GetLanguage(), Extensions: []string{".toml"}},
+		{Name: "bash", Grammar: bash.GetLanguage(),
+			Extensions: []string{".sh", ".bash"}, Aliases: []string{"sh", "shell", "zsh"},
+			Filenames: []string{".bashrc", ".bash_profile", ".profile"},
+			Interpreters: []string{"sh", "bash", "zsh", "dash"}},
+		{Name: "c", Grammar: c.GetLanguage(), Extensions: []string{".c", ".h"},
+			Tags: c_tags, Highlights: c_highlights,
+			Folds: c_folds},
+		{Name: "cpp", Grammar: cpp.GetLanguage(),
+			Extensions: []string{".cc", ".cpp", ".cxx", ".hh", ".hpp"}, Aliases: []string{"c++"}},
+		{Name: "java", Grammar: java.GetLanguage(), Extensions: []string{".java"}},
+		{Name: "ruby", Grammar: ruby.GetLanguage(),
+			Extensions: []string{".rb"}, Aliases: []string{"rb"},
+			Filenames: []string{"Rakefile", "Gemfile"}, Interpreters: []string{"ruby"}},
+		{Name: "lua", Grammar: lua.GetLanguage(),
+			Extensions: []string{".lua"}, Interpreters: []string{"lua"}},
+		{Name: "sql", Grammar: sql.GetLanguage(), Extensions: []string{".sql"}},
+		{Name: "css", Grammar: css.GetLanguage(), Extensions: []string{".css"}},
+		{Name: "html", Grammar: html.GetLanguage(),
+			Extensions: []string{".html", ".htm"},
+			Injections: html_injections},
+		{Name: "dockerfile", Grammar: dockerfile.GetLanguage(),
+			Filenames: []string{"Dockerfile", "Containerfile"}, Aliases: []string{"docker"}},
+	}
+}
+
+type Registry struct {
+	mu      sync.RWMutex
+	names   map[string]*Language // names and aliases, lower case
+	exts    map[string]*Language
+	files   map[string]*Language
+	interps map[string]*Language
+}
+
+func NewRegistry(langs ...*Language) *Registry {
+	r := &Registry{
+		names:   map[string]*Language{},
+		exts:    map[string]*Language{},
+		files:   map[string]*Language{},
+		interps: map[string]*Language{},
+	}
+	for _, l := range langs {
+		r.Register(l)
+	}
+	return r
+}
+
+// DefaultRegistry returns a registry of every grammar we build with.
+func DefaultRegistry() *Registry {
+	return NewRegistry(builtin_languages()...)
+}
+
+// Register adds l, taking over any name, extension or interpreter an
+// earlier language had.
+func (r *Registry)Register(l *Language) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names[strings.ToLower(l.Name)] = l
+	for _, a := range l.Aliases {
+		r.names[strings.ToLower(a)] = l
+	}
+	for _, e := range l.Extensions {
+		r.exts[strings.ToLower(e)] = l
+	}
+	for _, f := range l.Filenames {
+		r.files[f] = l
+	}
+	for _, i := range l.Interpreters {
+		r.interps[i] = l
+	}
+}
+
+// Lookup finds a language by name or alias, ignoring case.
+func (r *Registry)Lookup(name string) *Language {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.names[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// ForPath finds a language from a file name alone.
+func (r *Registry)ForPath(path string) *Language {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	base := filepath.Base(path)
+	if l := r.files[base]; l != nil {
+		return l
+	}
+	return r.exts[strings.ToLower(filepath.Ext(base))]
+}
+
+var (
+	vim_modeline   = regexp.MustCompile(`(?:^|\s)(?:vi|vim|ex):.*?\b(?:ft|filetype|syntax)=([\w+-]+)`)
+	emacs_modeline = regexp.MustCompile(`-\*-\s*(?:.*?\bmode:\s*)?([\w+-]+?)\s*(?:;.*?)?-\*-`)
+	version_suffix = regexp.MustCompile(`[\d.]+$`)
+)
+
+// lines at either end of a document where we look for modelines
+const modeline_lines = 5
+
+func (r *Registry)forModeline(line string) *Language {
+	for _, re := range []*regexp.Regexp{vim_modeline, emacs_modeline} {
+		if m := re.FindStringSubmatch(line); m != nil {
+			if l := r.Lookup(m[1]); l != nil {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Registry)forShebang(line string) *Language {
+	if !strings.HasPrefix(line, "#!") {
+		return nil
+	}
+	prog := ""
+	for _, f := range strings.Fields(line[2:]) {
+		f = filepath.Base(f)
+		// env takes flags and variables before the program
+		if f != "env" && !strings.HasPrefix(f, "-") && !strings.Contains(f, "=") {
+			prog = f
+			break
+		}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if l := r.interps[prog]; l != nil {
+		return l
+	}
+	return r.interps[version_suffix.ReplaceAllString(prog, "")]
+}
+
+// ends returns the first and last few lines of t
+func ends(t *Trie[byte]) []string {
+	head := make([]byte, min(t.Size(), 1024))
+	t.ReadInto(0, head)
+	tail := make([]byte, min(t.Size(), 1024))
+	t.ReadInto(t.Size()-len(tail), tail)
+
+	lines := strings.SplitN(string(head), "\n", modeline_lines+1)
+	lines = lines[:min(len(lines), modeline_lines)]
+	last := strings.Split(strings.TrimRight(string(tail), "\n"), "\n")
+	return append(lines, last[max(0, len(last)-modeline_lines):]...)
+}
+
+// Detect works out the language of a document from its path and text, and
+// returns nil for plain text.
+func (r *Registry)Detect(path string, text *Trie[byte]) *Language {
+	lines := ends(text)
+	for _, line := range lines {
+		if l := r.forModeline(line); l != nil {
+			return l
+		}
+	}
+	if len(lines) > 0 {
+		if l := r.forShebang(lines[0]); l != nil {
+			return l
+		}
+	}
+	return r.ForPath(path)
+}
+
+// Open makes a document for the file at path, parsed with whatever language
+// Detect finds for it.
+func (r *Registry)Open(path string, text []byte) (*Document, *Language) {
+	t := TrieFromSlice[byte](text)
+	l := r.Detect(path, t)
+	if l == nil {
+		return new_document(nil, t), nil
+	}
+	return new_document(l.Grammar, t), l
+}
+
+type Injection struct {
+	Language *Language
+	Ranges   []sitter.Range
+	Tree     *sitter.Tree
+}
+
+// nodeText reads the text n spans out of t
+func nodeText(t *Trie[byte], n *sitter.Node) []byte {
+	p := make([]byte, n.EndByte()-n.StartByte())
+	t.ReadInto(int(n.StartByte()), p)
+	return p
+}
+
+// injected_language reads the language a match is in, from a capture or from
+// a set! predicate of its pattern
+func injected_language(q *sitter.Query, m *sitter.QueryMatch, text *Trie[byte]) string {
+	for _, c := range m.Captures {
+		if q.CaptureNameForId(c.Index) == "injection.language" {
+			return string(nodeText(text, c.Node))
+		}
+	}
+	for _, steps := range q.PredicatesForPattern(uint32(m.PatternIndex)) {
+		if len(steps) == 4 &&
+			q.StringValueForId(steps[0].ValueId) == "set!" &&
+			q.StringValueForId(steps[1].ValueId) == "injection.language" {
+			return q.StringValueForId(steps[2].ValueId)
+		}
+	}
+	return ""
+}
+
+// Injections parses the parts of v written in other languages. host is the
+// language v was parsed with. Languages the registry does not know are
+// skipped. Injections are not looked for inside injections.
+func (r *Registry)Injections(host *Language, v *Version) []*Injection {
+	if host == nil || v.Tree == nil || host.Injections == "" {
+		return nil
+	}
+	q := host.query(host.Injections)
+	qc := sitter.NewQueryCursor()
+	defer qc.Close()
+	qc.Exec(q, v.Tree.RootNode())
+
+	var out []*Injection
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		l := r.Lookup(injected_language(q, m, v.Text))
+		if l == nil {
+			continue
+		}
+		var ranges []sitter.Range
+		for _, c := range m.Captures {
+			if q.CaptureNameForId(c.Index) == "injection.content" {
+				ranges = append(ranges, c.Node.Range())
+			}
+		}
+		if len(ranges) == 0 {
+			continue
+		}
+		p := sitter.NewParser()
+		p.SetLanguage(l.Grammar)
+		p.SetIncludedRanges(ranges)
+		out = append(out, &Injection{l, ranges, p.ParseInput(nil, TrieInput(v.Text))})
+	}
+	return out
+}
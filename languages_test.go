@@ -0,0 +1,133 @@
+package web
+
+import (
+	"strings"
+	"testing"
+)
+
+func detect(r *Registry, path, text string) string {
+	l := r.Detect(path, TrieFromSlice[byte]([]byte(text)))
+	if l == nil {
+		return ""
+	}
+	return l.Name
+}
+
+func TestDetect(t *testing.T) {
+	r := DefaultRegistry()
+	cases := []struct{
+		path, text, want string
+	}{
+		{"main.go", "package main\n", "go"},
+		{"lib/thing.PY", "x = 1\n", "python"},
+		{"src/app.tsx", "", "tsx"},
+		{"docs/page.md", "# hi\n", "markdown"},
+		{"config.yml", "a: 1\n", "yaml"},
+		{"Dockerfile", "FROM scratch\n", "dockerfile"},
+		{"notes.txt", "nothing to see\n", ""},
+		{"run", "#!/bin/sh\necho hi\n", "bash"},
+		{"run", "#!/usr/bin/env python3\nprint(1)\n", "python"},
+		{"run", "#!/usr/bin/env -S node --no-warnings\n", "javascript"},
+		{"run", "#!/usr/local/bin/python3.11\n", "python"},
+		{"run", "#!/usr/bin/env FOO=1 ruby\n", "ruby"},
+		{"build", "# vim: set ft=python ts=4:\nx = 1\n", "python"},
+		{"build", "# -*- mode: ruby; coding: utf-8 -*-\n", "ruby"},
+		{"build", "// -*- go -*-\n", "go"},
+		// modelines beat the extension, and are found at the end too
+		{"page.txt", strings.Repeat("line\n", 50) + "<!-- vim: ft=markdown -->\n", "markdown"},
+		{"script.py", "#!/usr/bin/env node\n// vi: syntax=js\n", "javascript"},
+		// but not in the middle
+		{"page.txt", strings.Repeat("line\n", 20) + "vim: ft=go\n" + strings.Repeat("line\n", 20), ""},
+	}
+	for _, c := range cases {
+		if got := detect(r, c.path, c.text); got != c.want {
+			t.Errorf("Detect(%q, %q) = %q, want %q", c.path, c.text, got, c.want)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := DefaultRegistry()
+	if r.Lookup("PY") != r.Lookup("python") || r.Lookup("golang").Name != "go" {
+		t.Fatalf("aliases do not resolve")
+	}
+	r.Register(&Language{Name: "wiki", Grammar: r.Lookup("markdown").Grammar,
+		Extensions: []string{".wiki", ".md"}})
+	if l := r.ForPath("Home.wiki"); l == nil || l.Name != "wiki" {
+		t.Fatalf("ForPath(Home.wiki) = %v", l)
+	}
+	if l := r.ForPath("README.md"); l == nil || l.Name != "wiki" {
+		t.Fatalf("a later language should take over .md, got %v", l)
+	}
+}
+
+func TestSetLanguage(t *testing.T) {
+	r := DefaultRegistry()
+	d, l := r.Open("notes.txt", []byte("def f():\n    return 1\n"))
+	if l != nil || d.Current().Tree != nil {
+		t.Fatalf("plain text got language %v", l)
+	}
+	v := d.SetLanguage(r.Lookup("python").Grammar)
+	if v.Rev != 1 || v.Edit != nil || v.Tree.RootNode().Type() != "module" {
+		t.Fatalf("after SetLanguage(python): rev %d, %v", v.Rev, v.Tree.RootNode())
+	}
+	v, _ = d.Insert(0, []byte("x = 2\n"))
+	if v.Tree.RootNode().NamedChildCount() != 2 {
+		t.Fatalf("incremental parse after a switch: %v", v.Tree.RootNode())
+	}
+	v = d.SetLanguage(nil)
+	if v.Tree != nil {
+		t.Fatalf("SetLanguage(nil) kept a tree")
+	}
+}
+
+const wiki_page = "# Notes\n\nSome Go:\n\n```go\nfunc main() {}\n```\n\nand python\n\n" +
+	"```py\ndef f():\n    pass\n```\n\n```nosuchlang\nwhatever\n```\n"
+
+func TestInjections(t *testing.T) {
+	r := DefaultRegistry()
+	d, md := r.Open("page.md", []byte(wiki_page))
+	inj := r.Injections(md, d.Current())
+	if len(inj) != 2 {
+		t.Fatalf("found %d injections, want 2", len(inj))
+	}
+
+	goblock := inj[0]
+	if goblock.Language.Name != "go" {
+		t.Fatalf("first injection is %s", goblock.Language.Name)
+	}
+	fn := goblock.Tree.RootNode().NamedChild(0)
+	if fn == nil || fn.Type() != "function_declaration" {
+		t.Fatalf("go block parsed as %v", goblock.Tree.RootNode())
+	}
+	// offsets are into the page, not the block
+	if got := string(nodeText(d.Current().Text, fn)); got != "func main() {}" {
+		t.Fatalf("function spans %q", got)
+	}
+
+	if inj[1].Language.Name != "python" {
+		t.Fatalf("second injection is %s", inj[1].Language.Name)
+	}
+	if got := inj[1].Tree.RootNode().NamedChild(0).Type(); got != "function_definition" {
+		t.Fatalf("python block starts with %s", got)
+	}
+
+	// and they follow edits
+	v, _ := d.Insert(strings.Index(wiki_page, "func main"), []byte("type T int\n"))
+	inj = r.Injections(md, v)
+	if n := inj[0].Tree.RootNode().NamedChildCount(); n != 2 {
+		t.Fatalf("go block has %d declarations after the edit", n)
+	}
+}
+
+func TestInjectionsSet(t *testing.T) {
+	r := DefaultRegistry()
+	d, html := r.Open("index.html", []byte("<html><script>let x = 1;</script><style>p { color: red }</style></html>"))
+	inj := r.Injections(html, d.Current())
+	if len(inj) != 2 || inj[0].Language.Name != "javascript" || inj[1].Language.Name != "css" {
+		t.Fatalf("injections %v", inj)
+	}
+	if r.Injections(r.Lookup("go"), d.Current()) != nil {
+		t.Fatalf("go has no injection query")
+	}
+}
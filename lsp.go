@@ -0,0 +1,616 @@
+/* The language server lets editors work on the documents of a Project over
+   the language server protocol, JSON-RPC framed with Content-Length headers,
+   usually on stdin and stdout, see cmd/web-lsp.
+   Documents are keyed by their URI. Changes come in as incremental edits
+   and go straight to Project.Replace, so the Trie[byte] of each version, its
+   tree, the index and the diagnostics all follow. The editor keeps the
+   history, so once a change is in the versions before it are forgotten.
+   LSP counts characters in UTF-16 code units, we count bytes. line_index
+   converts between the two for one version of a text. The server keeps one
+   for the current text of each document, and a change only looks at the
+   lines it touched.
+ */
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// line_index has where each line of a text starts
+type line_index struct {
+	text   *Trie[byte]
+	starts []int
+}
+
+func newLineIndex(t *Trie[byte]) line_index {
+	li := line_index{text: t, starts: []int{0}}
+	t.eachLeaf(0, func(off int, leaf []byte) bool {
+		for i := bytes.IndexByte(leaf, '\n'); i >= 0; {
+			li.starts = append(li.starts, off+i+1)
+			j := bytes.IndexByte(leaf[i+1:], '\n')
+			if j < 0 {
+				break
+			}
+			i += j+1
+		}
+		return true
+	})
+	return li
+}
+
+// edited returns the index of t, which is li.text with start to end
+// replaced by text. The lines the edit touched are found again, the ones
+// after it just move; li has its starts taken over and is not to be used
+// after.
+func (li line_index)edited(t *Trie[byte], start, end int, text []byte) line_index {
+	// lines that start in start+1 to end began in what is gone
+	first := sort.SearchInts(li.starts, start+1)
+	last := sort.SearchInts(li.starts, end+1)
+	var added []int
+	for i := bytes.IndexByte(text, '\n'); i >= 0; {
+		added = append(added, start+i+1)
+		j := bytes.IndexByte(text[i+1:], '\n')
+		if j < 0 {
+			break
+		}
+		i += j+1
+	}
+	grown := len(text)-(end-start)
+	for i := last; i < len(li.starts); i++ {
+		li.starts[i] += grown
+	}
+	return line_index{text: t, starts: slices.Replace(li.starts, first, last, added...)}
+}
+
+// line returns the bytes of a line, without its newline
+func (li line_index)line(n int) (int, []byte) {
+	start, end := li.starts[n], li.text.Size()
+	if n+1 < len(li.starts) {
+		end = li.starts[n+1]-1
+	}
+	return start, textRange(li.text, start, end)
+}
+
+func utf16_len(p []byte) int {
+	n := 0
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		n += utf16.RuneLen(r)
+		p = p[size:]
+	}
+	return n
+}
+
+func (li line_index)position(off int) Position {
+	n := sort.SearchInts(li.starts, off+1)-1
+	return Position{n, utf16_len(textRange(li.text, li.starts[n], off))}
+}
+
+// offset returns the byte offset of p. A position past the end of its line
+// is the end of the line, and one past the last line is the end of the text.
+func (li line_index)offset(p Position) int {
+	if p.Line >= len(li.starts) {
+		return li.text.Size()
+	}
+	start, line := li.line(p.Line)
+	i := 0
+	for n := 0; i < len(line) && n < p.Character; {
+		r, size := utf8.DecodeRune(line[i:])
+		n += utf16.RuneLen(r)
+		i += size
+	}
+	return start+i
+}
+
+func (li line_index)lsp_range(r sitter.Range) Range {
+	return Range{li.position(int(r.StartByte)), li.position(int(r.EndByte))}
+}
+
+type lsp_error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lsp_error)Error() string {
+	return e.Message
+}
+
+var (
+	lsp_parse_error      = &lsp_error{-32700, "parse error"}
+	lsp_invalid_params   = &lsp_error{-32602, "invalid params"}
+	lsp_method_not_found = &lsp_error{-32601, "method not found"}
+	lsp_not_open         = &lsp_error{-32602, "document is not open"}
+)
+
+type lsp_message struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// the semantic token types we send, in the order of the legend
+var token_types = []string{"namespace", "type", "class", "function", "method",
+	"macro", "keyword", "comment", "string", "number", "regexp", "operator",
+	"decorator", "property", "variable"}
+
+// token_type maps a highlight capture to its place in token_types, or -1
+func token_type(capture string) int {
+	var name string
+	switch {
+	case capture == "module":
+		name = "namespace"
+	case capture == "string.special":
+		name = "regexp"
+	case capture == "function.method":
+		name = "method"
+	case capture == "function.macro":
+		name = "macro"
+	case capture == "attribute":
+		name = "decorator"
+	case capture == "constant.builtin":
+		name = "keyword"
+	case strings.HasPrefix(capture, "punctuation"):
+		name = "operator"
+	default:
+		name, _, _ = strings.Cut(capture, ".")
+	}
+	for i, t := range token_types {
+		if t == name {
+			return i
+		}
+	}
+	return -1
+}
+
+var symbol_kinds = map[string]int{
+	"heading": 3, "class": 5, "method": 6, "interface": 11,
+	"function": 12, "constant": 14, "type": 23,
+}
+
+type LSPServer struct {
+	project *Project
+	reg     *Registry
+	wmu     sync.Mutex
+	out     *bufio.Writer
+	// highlighters by URI, for semantic tokens
+	highlighters map[string]*Highlighter
+	// line indexes by URI, of the current text
+	lines    map[string]line_index
+	shutdown bool
+}
+
+func NewLSPServer(reg *Registry) *LSPServer {
+	return &LSPServer{project: NewProject(reg), reg: reg, highlighters: map[string]*Highlighter{},
+		lines: map[string]line_index{}}
+}
+
+func (s *LSPServer)Project() *Project {
+	return s.project
+}
+
+// Serve answers the messages read from r on w until the client sends exit,
+// or r ends.
+func (s *LSPServer)Serve(r io.Reader, w io.Writer) error {
+	s.out = bufio.NewWriter(w)
+	cancel := s.project.Diagnostics().Subscribe(s.publish)
+	defer cancel()
+
+	in := textproto.NewReader(bufio.NewReader(r))
+	for {
+		header, err := in.ReadMIMEHeader()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			return fmt.Errorf("web: bad Content-Length: %w", err)
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(in.R, body); err != nil {
+			return err
+		}
+
+		var m lsp_message
+		if err := json.Unmarshal(body, &m); err != nil {
+			s.reply(json.RawMessage("null"), nil, lsp_parse_error)
+			continue
+		}
+		if m.Method == "exit" {
+			return nil
+		}
+		result, lerr := s.handle(m.Method, m.Params)
+		if m.ID != nil {
+			s.reply(m.ID, result, lerr)
+		}
+	}
+}
+
+func (s *LSPServer)write(msg map[string]any) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(body))
+	s.out.Write(body)
+	s.out.Flush()
+}
+
+func (s *LSPServer)reply(id json.RawMessage, result any, err *lsp_error) {
+	msg := map[string]any{"jsonrpc": "2.0", "id": id}
+	if err != nil {
+		msg["error"] = err
+	} else {
+		msg["result"] = result
+	}
+	s.write(msg)
+}
+
+func (s *LSPServer)notify(method string, params any) {
+	s.write(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// publish sends the diagnostics of a report
+func (s *LSPServer)publish(r Report) {
+	out := []map[string]any{}
+	if len(r.Diagnostics) > 0 {
+		li := newLineIndex(r.Text)
+		for _, diag := range r.Diagnostics {
+			out = append(out, map[string]any{
+				"range":    li.lsp_range(diag.Range),
+				"severity": 1,
+				"source":   diag.Language,
+				"message":  diag.Message,
+			})
+		}
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]any{"uri": r.Path, "diagnostics": out})
+}
+
+type text_document struct {
+	URI string `json:"uri"`
+}
+
+type position_params struct {
+	TextDocument text_document `json:"textDocument"`
+	Position     Position      `json:"position"`
+	Context      struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+func (s *LSPServer)handle(method string, params json.RawMessage) (any, *lsp_error) {
+	decode := func(v any) *lsp_error {
+		if err := json.Unmarshal(params, v); err != nil {
+			return lsp_invalid_params
+		}
+		return nil
+	}
+	if s.shutdown {
+		return nil, &lsp_error{-32600, "shut down"}
+	}
+	switch method {
+	case "initialize":
+		return s.initialize(), nil
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI        string `json:"uri"`
+				LanguageID string `json:"languageId"`
+				Text       string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		s.open(p.TextDocument.URI, p.TextDocument.LanguageID, p.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument   text_document `json:"textDocument"`
+			ContentChanges []struct {
+				Range *Range `json:"range"`
+				Text  string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		for _, c := range p.ContentChanges {
+			if err := s.change(p.TextDocument.URI, c.Range, c.Text); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p struct {
+			TextDocument text_document `json:"textDocument"`
+		}
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		s.project.Remove(p.TextDocument.URI)
+		delete(s.highlighters, p.TextDocument.URI)
+		delete(s.lines, p.TextDocument.URI)
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var p struct {
+			TextDocument text_document `json:"textDocument"`
+		}
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.symbols(p.TextDocument.URI)
+	case "textDocument/definition", "textDocument/references":
+		var p position_params
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.locations(method == "textDocument/references", p)
+	case "textDocument/foldingRange":
+		var p struct {
+			TextDocument text_document `json:"textDocument"`
+		}
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.folds(p.TextDocument.URI)
+	case "textDocument/semanticTokens/full", "textDocument/semanticTokens/range":
+		var p struct {
+			TextDocument text_document `json:"textDocument"`
+			Range        *Range        `json:"range"`
+		}
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.tokens(p.TextDocument.URI, p.Range)
+	}
+	return nil, lsp_method_not_found
+}
+
+func (s *LSPServer)initialize() any {
+	return map[string]any{
+		"capabilities": map[string]any{
+			"positionEncoding": "utf-16",
+			"textDocumentSync": map[string]any{
+				"openClose": true,
+				"change":    2, // incremental
+			},
+			"documentSymbolProvider": true,
+			"definitionProvider":     true,
+			"referencesProvider":     true,
+			"foldingRangeProvider":   true,
+			"semanticTokensProvider": map[string]any{
+				"legend": map[string]any{
+					"tokenTypes":     token_types,
+					"tokenModifiers": []string{},
+				},
+				"full":  true,
+				"range": true,
+			},
+		},
+		"serverInfo": map[string]any{"name": "web-lsp"},
+	}
+}
+
+func (s *LSPServer)open(uri, language_id, text string) {
+	_, l := s.project.Add(uri, []byte(text))
+	if l == nil {
+		// editors know better than the URI at times, for unsaved files
+		if l = s.reg.Lookup(language_id); l != nil {
+			s.project.SetLanguage(uri, l)
+		}
+	}
+	s.highlighters[uri] = NewHighlighter(l, s.reg)
+}
+
+func (s *LSPServer)change(uri string, r *Range, text string) *lsp_error {
+	d := s.project.Document(uri)
+	if d == nil {
+		return lsp_not_open
+	}
+	li := s.line_index(uri, d.Current().Text)
+	start, end := 0, li.text.Size()
+	if r != nil {
+		start, end = li.offset(r.Start), li.offset(r.End)
+		if end < start {
+			return lsp_invalid_params
+		}
+	}
+	v, err := s.project.Replace(uri, start, end-start, []byte(text))
+	if err != nil {
+		if errors.Is(err, ErrNoDocument) {
+			return lsp_not_open
+		}
+		return &lsp_error{-32603, err.Error()}
+	}
+	s.lines[uri] = li.edited(v.Text, start, end, []byte(text))
+	// v is indexed, and the editor has the history
+	d.Forget(v.Rev)
+	return nil
+}
+
+// line_index returns the index of t, the current text of uri, from the one
+// kept when it is of t
+func (s *LSPServer)line_index(uri string, t *Trie[byte]) line_index {
+	if li, ok := s.lines[uri]; ok && li.text == t {
+		return li
+	}
+	li := newLineIndex(t)
+	s.lines[uri] = li
+	return li
+}
+
+func (s *LSPServer)text(uri string) (line_index, *lsp_error) {
+	d := s.project.Document(uri)
+	if d == nil {
+		return line_index{}, lsp_not_open
+	}
+	return s.line_index(uri, d.Current().Text), nil
+}
+
+func (s *LSPServer)symbols(uri string) (any, *lsp_error) {
+	li, err := s.text(uri)
+	if err != nil {
+		return nil, err
+	}
+	var convert func(items []*OutlineItem) []map[string]any
+	convert = func(items []*OutlineItem) []map[string]any {
+		out := []map[string]any{}
+		for _, it := range items {
+			kind, ok := symbol_kinds[it.Kind]
+			if !ok {
+				kind = 13 // variable
+			}
+			out = append(out, map[string]any{
+				"name":           it.Name,
+				"kind":           kind,
+				"range":          li.lsp_range(it.Range),
+				"selectionRange": li.lsp_range(it.NameRange),
+				"children":       convert(it.Children),
+			})
+		}
+		return out
+	}
+	return convert(s.project.Index().Outline(uri)), nil
+}
+
+func (s *LSPServer)locations(references bool, p position_params) (any, *lsp_error) {
+	uri := p.TextDocument.URI
+	li, err := s.text(uri)
+	if err != nil {
+		return nil, err
+	}
+	off := li.offset(p.Position)
+	// the line indexes of the documents we point into
+	texts := map[string]line_index{uri: li}
+	location := func(path string, r sitter.Range) (Location, bool) {
+		t, ok := texts[path]
+		if !ok {
+			if t, err = s.text(path); err != nil {
+				return Location{}, false
+			}
+			texts[path] = t
+		}
+		return Location{path, t.lsp_range(r)}, true
+	}
+
+	out := []Location{}
+	var defs []Symbol
+	if !references || p.Context.IncludeDeclaration {
+		defs = s.project.Definition(uri, off)
+	}
+	for _, d := range defs {
+		if l, ok := location(d.Path, d.NameRange); ok {
+			out = append(out, l)
+		}
+	}
+	if references {
+		for _, r := range s.project.References(uri, off) {
+			if l, ok := location(r.Path, r.Range); ok {
+				out = append(out, l)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (s *LSPServer)folds(uri string) (any, *lsp_error) {
+	d := s.project.Document(uri)
+	if d == nil {
+		return nil, lsp_not_open
+	}
+	out := []map[string]any{}
+	for _, f := range Folds(s.project.Language(uri), d.Current()) {
+		fold := map[string]any{"startLine": f.StartRow, "endLine": f.EndRow}
+		if f.Kind != "" {
+			fold["kind"] = f.Kind
+		}
+		out = append(out, fold)
+	}
+	return out, nil
+}
+
+// tokens encodes the highlights in r, or the whole document, as semantic
+// tokens. a token can not span lines, so highlights that do are split.
+func (s *LSPServer)tokens(uri string, r *Range) (any, *lsp_error) {
+	d := s.project.Document(uri)
+	if d == nil {
+		return nil, lsp_not_open
+	}
+	v := d.Current()
+	h := s.highlighters[uri]
+	if h == nil || h.lang != s.project.Language(uri) {
+		h = NewHighlighter(s.project.Language(uri), s.reg)
+		s.highlighters[uri] = h
+	}
+	li := s.line_index(uri, v.Text)
+	start, end := 0, v.Text.Size()
+	if r != nil {
+		start, end = li.offset(r.Start), li.offset(r.End)
+	}
+
+	data := []int{}
+	last := Position{}
+	for _, hl := range h.Highlights(v, start, end) {
+		typ := token_type(hl.Capture)
+		if typ < 0 {
+			continue
+		}
+		for from := hl.Start; from < hl.End; {
+			p := li.position(from)
+			line_start, line := li.line(p.Line)
+			to := min(hl.End, line_start+len(line))
+			if to > from {
+				n := utf16_len(textRange(v.Text, from, to))
+				delta := p.Character
+				if p.Line == last.Line {
+					delta -= last.Character
+				}
+				data = append(data, p.Line-last.Line, delta, n, typ, 0)
+				last = p
+			}
+			from = line_start+len(line)+1
+		}
+	}
+	return map[string]any{"data": data}, nil
+}
@@ -0,0 +1,228 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLineIndex(t *testing.T) {
+	text := "a😀b\né\n\nlast"
+	li := newLineIndex(TrieFromSlice[byte]([]byte(text)))
+	cases := []struct {
+		off int
+		p   Position
+	}{
+		{0, Position{0, 0}},
+		{1, Position{0, 1}},
+		{5, Position{0, 3}}, // the emoji is two UTF-16 units
+		{6, Position{0, 4}},
+		{7, Position{1, 0}},
+		{9, Position{1, 1}},
+		{10, Position{2, 0}},
+		{11, Position{3, 0}},
+		{len(text), Position{3, 4}},
+	}
+	for _, c := range cases {
+		if p := li.position(c.off); p != c.p {
+			t.Errorf("position(%d) = %v, want %v", c.off, p, c.p)
+		}
+		if off := li.offset(c.p); off != c.off {
+			t.Errorf("offset(%v) = %d, want %d", c.p, off, c.off)
+		}
+	}
+	// past the end of a line, and of the text
+	if off := li.offset(Position{1, 9}); off != 9 {
+		t.Errorf("offset past the end of line 1 = %d", off)
+	}
+	if off := li.offset(Position{9, 0}); off != len(text) {
+		t.Errorf("offset past the last line = %d", off)
+	}
+}
+
+// an index kept up to date through edits is the one made from scratch
+func TestLineIndexEdited(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	text := []byte("one\ntwo\n\nthree")
+	li := newLineIndex(TrieFromSlice[byte](text))
+	for i := 0; i < 500; i++ {
+		start := rng.Intn(len(text)+1)
+		end := start+rng.Intn(min(6, len(text)-start)+1)
+		ins := []byte([]string{"", "x", "\n", "a\nb", "\n\n"}[rng.Intn(5)])
+		text = append(append(append([]byte(nil), text[:start]...), ins...), text[end:]...)
+		li = li.edited(TrieFromSlice[byte](text), start, end, ins)
+		if want := newLineIndex(li.text); !reflect.DeepEqual(li.starts, want.starts) {
+			t.Fatalf("after edit %d starts are %v, want %v", i, li.starts, want.starts)
+		}
+	}
+}
+
+// lsp_session writes messages in the framing of the protocol
+type lsp_session struct {
+	in  bytes.Buffer
+	ids int
+}
+
+func (s *lsp_session)send(method string, params any, request bool) {
+	msg := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+	if request {
+		s.ids++
+		msg["id"] = s.ids
+	}
+	body, _ := json.Marshal(msg)
+	fmt.Fprintf(&s.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+type lsp_reply struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *lsp_error      `json:"error"`
+}
+
+func read_replies(t *testing.T, r io.Reader) []lsp_reply {
+	in := textproto.NewReader(bufio.NewReader(r))
+	var out []lsp_reply
+	for {
+		h, err := in.ReadMIMEHeader()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := strconv.Atoi(h.Get("Content-Length"))
+		body := make([]byte, n)
+		if _, err := io.ReadFull(in.R, body); err != nil {
+			t.Fatal(err)
+		}
+		var rep lsp_reply
+		if err := json.Unmarshal(body, &rep); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, rep)
+	}
+}
+
+// the emoji in the comment makes UTF-16 columns differ from byte ones
+const lsp_source = "package main\n\n// 😀 helpers\nfunc helper() int {\n\treturn 1\n}\n\nfunc main() {\n\t/*😀*/ helper()\n}\n"
+
+func TestLSP(t *testing.T) {
+	var s lsp_session
+	uri := "file:///src/main.go"
+	doc := map[string]any{"uri": uri}
+	s.send("initialize", map[string]any{"capabilities": map[string]any{}}, true)
+	s.send("initialized", map[string]any{}, false)
+	s.send("textDocument/didOpen", map[string]any{"textDocument": map[string]any{
+		"uri": uri, "languageId": "go", "version": 1, "text": lsp_source}}, false)
+	// on line 8 helper starts at UTF-16 column 8, byte column 10
+	s.send("textDocument/definition", map[string]any{"textDocument": doc,
+		"position": Position{8, 9}}, true)
+	s.send("textDocument/references", map[string]any{"textDocument": doc,
+		"position": Position{3, 6}, "context": map[string]any{"includeDeclaration": true}}, true)
+	// rename the use, and break main
+	s.send("textDocument/didChange", map[string]any{"textDocument": doc, "contentChanges": []any{
+		map[string]any{"range": Range{Position{8, 8}, Position{8, 14}}, "text": "other"},
+		map[string]any{"range": Range{Position{9, 0}, Position{9, 1}}, "text": ""},
+	}}, false)
+	s.send("textDocument/documentSymbol", map[string]any{"textDocument": doc}, true)
+	s.send("textDocument/foldingRange", map[string]any{"textDocument": doc}, true)
+	s.send("textDocument/semanticTokens/range", map[string]any{"textDocument": doc,
+		"range": Range{Position{8, 0}, Position{9, 0}}}, true)
+	s.send("textDocument/hover", map[string]any{"textDocument": doc, "position": Position{0, 0}}, true)
+	s.send("shutdown", nil, true)
+	s.send("exit", nil, false)
+
+	var out bytes.Buffer
+	server := NewLSPServer(DefaultRegistry())
+	if err := server.Serve(&s.in, &out); err != nil {
+		t.Fatal(err)
+	}
+	// the editor has the history, the server only the text now
+	if texts := server.Project().Document(uri).Texts(); len(texts) != 1 {
+		t.Fatalf("%d versions kept after the changes", len(texts))
+	}
+	replies := map[int]json.RawMessage{}
+	var diagnostics []json.RawMessage
+	for _, r := range read_replies(t, &out) {
+		switch {
+		case r.Method == "textDocument/publishDiagnostics":
+			diagnostics = append(diagnostics, r.Params)
+		case r.Error != nil:
+			replies[*r.ID] = json.RawMessage(fmt.Sprint(r.Error.Code))
+		default:
+			replies[*r.ID] = r.Result
+		}
+	}
+	decode := func(id int, v any) {
+		t.Helper()
+		if err := json.Unmarshal(replies[id], v); err != nil {
+			t.Fatalf("reply %d: %s: %v", id, replies[id], err)
+		}
+	}
+
+	var init struct {
+		Capabilities struct {
+			TextDocumentSync struct{ Change int }
+		}
+	}
+	decode(1, &init)
+	if init.Capabilities.TextDocumentSync.Change != 2 {
+		t.Fatalf("initialize = %s", replies[1])
+	}
+
+	var defs []Location
+	decode(2, &defs)
+	if want := []Location{{uri, Range{Position{3, 5}, Position{3, 11}}}}; !reflect.DeepEqual(defs, want) {
+		t.Fatalf("definition = %v, want %v", defs, want)
+	}
+	var refs []Location
+	decode(3, &refs)
+	if len(refs) != 2 || refs[1].Range != (Range{Position{8, 8}, Position{8, 14}}) {
+		t.Fatalf("references = %v", refs)
+	}
+
+	var syms []struct {
+		Name string
+		Kind int
+	}
+	decode(4, &syms)
+	if len(syms) != 2 || syms[0].Name != "helper" || syms[0].Kind != 12 {
+		t.Fatalf("documentSymbol = %s", replies[4])
+	}
+	var folds []struct{ StartLine, EndLine int }
+	decode(5, &folds)
+	if len(folds) == 0 || folds[0].StartLine != 3 || folds[0].EndLine != 5 {
+		t.Fatalf("foldingRange = %s", replies[5])
+	}
+
+	var tokens struct{ Data []int }
+	decode(6, &tokens)
+	// the comment, then other as a call, on line 8
+	want := []int{8, 1, 6, token_type("comment"), 0, 0, 7, 5, token_type("function.call"), 0}
+	if !reflect.DeepEqual(tokens.Data, want) {
+		t.Fatalf("semanticTokens = %v, want %v", tokens.Data, want)
+	}
+
+	if string(replies[7]) != "-32601" {
+		t.Fatalf("hover = %s", replies[7])
+	}
+	if string(replies[8]) != "null" {
+		t.Fatalf("shutdown = %s", replies[8])
+	}
+
+	// one report when opened, and one per change
+	if len(diagnostics) != 3 || !strings.Contains(string(diagnostics[0]), `"diagnostics":[]`) ||
+		strings.Contains(string(diagnostics[2]), `"diagnostics":[]`) {
+		t.Fatalf("diagnostics %s", diagnostics)
+	}
+}
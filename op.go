@@ -0,0 +1,404 @@
+/* An Op is one change to a document, as a walk over all of it: retain n
+   bytes, insert some, delete n. It is the unit of change for undo, the op
+   log and the network, so a change can be applied to any Trie[byte] of the
+   right size, composed with the ones after it, inverted, and sent.
+   Ops are kept normalised: no empty components, no two of a kind in a row,
+   and an insert always comes before a delete it touches, so two ops that do
+   the same thing are equal.
+   On the wire an op is a JSON array in the style of ot.js, a retain is a
+   positive number, a delete a negative one and an insert a string, or a
+   compact binary form of varints.
+ */
+package web
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+var (
+	ErrOpLength   = errors.New("web: op does not fit the document")
+	ErrOpCompose  = errors.New("web: ops do not compose")
+	ErrOpEncoding = errors.New("web: bad op encoding")
+)
+
+// Component is one step of an Op, exactly one of its fields is set.
+type Component struct {
+	Retain int
+	Insert []byte
+	Delete int
+}
+
+// The zero Op is empty. Build one up with Retain, Insert and Delete, and
+// leave it alone after that, as copies share their components.
+type Op struct {
+	comps  []Component
+	base   int // the size of the documents it applies to
+	target int // and of what it makes of them
+}
+
+func (o Op)Components() []Component {
+	return o.comps
+}
+
+// BaseLen is the size of the document o applies to, TargetLen the size of
+// the result.
+func (o Op)BaseLen() int {
+	return o.base
+}
+
+func (o Op)TargetLen() int {
+	return o.target
+}
+
+// IsNoop reports whether o leaves documents as they are.
+func (o Op)IsNoop() bool {
+	return len(o.comps) == 0 || (len(o.comps) == 1 && o.comps[0].Retain > 0)
+}
+
+func (o Op)String() string {
+	var b bytes.Buffer
+	for i, c := range o.comps {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		switch {
+		case c.Retain > 0:
+			fmt.Fprintf(&b, "retain %d", c.Retain)
+		case c.Delete > 0:
+			fmt.Fprintf(&b, "delete %d", c.Delete)
+		default:
+			fmt.Fprintf(&b, "insert %q", c.Insert)
+		}
+	}
+	return b.String()
+}
+
+func (o *Op)last() *Component {
+	if len(o.comps) == 0 {
+		return nil
+	}
+	return &o.comps[len(o.comps)-1]
+}
+
+// Retain appends a retain of n bytes to o.
+func (o *Op)Retain(n int) *Op {
+	if n <= 0 {
+		return o
+	}
+	o.base += n
+	o.target += n
+	if l := o.last(); l != nil && l.Retain > 0 {
+		l.Retain += n
+	} else {
+		o.comps = append(o.comps, Component{Retain: n})
+	}
+	return o
+}
+
+// Insert appends an insert of p to o, and copies p.
+func (o *Op)Insert(p []byte) *Op {
+	if len(p) == 0 {
+		return o
+	}
+	o.target += len(p)
+	l := o.last()
+	switch {
+	case l != nil && l.Insert != nil:
+		l.Insert = append(l.Insert, p...)
+	case l != nil && l.Delete > 0:
+		// an insert next to a delete goes first
+		if len(o.comps) > 1 && o.comps[len(o.comps)-2].Insert != nil {
+			prev := &o.comps[len(o.comps)-2]
+			prev.Insert = append(prev.Insert, p...)
+		} else {
+			o.comps = append(o.comps, *l)
+			o.comps[len(o.comps)-2] = Component{Insert: append([]byte(nil), p...)}
+		}
+	default:
+		o.comps = append(o.comps, Component{Insert: append([]byte(nil), p...)})
+	}
+	return o
+}
+
+// Delete appends a delete of n bytes to o.
+func (o *Op)Delete(n int) *Op {
+	if n <= 0 {
+		return o
+	}
+	o.base += n
+	if l := o.last(); l != nil && l.Delete > 0 {
+		l.Delete += n
+	} else {
+		o.comps = append(o.comps, Component{Delete: n})
+	}
+	return o
+}
+
+// ReplaceOp is the op that replaces n bytes at off, in a document of size
+// bytes, with p.
+func ReplaceOp(size, off, n int, p []byte) Op {
+	var o Op
+	o.Retain(off).Insert(p).Delete(n).Retain(size-off-n)
+	return o
+}
+
+// EditsOp is the op that makes edits, which are in order and do not overlap,
+// to a document of size bytes.
+func EditsOp(size int, edits []TextEdit) Op {
+	var o Op
+	at := 0
+	for _, e := range edits {
+		o.Retain(e.Start-at).Insert(e.Text).Delete(e.End-e.Start)
+		at = e.End
+	}
+	o.Retain(size-at)
+	return o
+}
+
+// Apply returns t changed by o. t is left as it is.
+func (o Op)Apply(t *Trie[byte]) (*Trie[byte], error) {
+	if t.Size() != o.base {
+		return nil, ErrOpLength
+	}
+	at := 0
+	for _, c := range o.comps {
+		switch {
+		case c.Retain > 0:
+			at += c.Retain
+		case c.Delete > 0:
+			t = t.Delete(0, at, c.Delete)
+		default:
+			t = t.Insert(0, at, c.Insert)
+			at += len(c.Insert)
+		}
+	}
+	return t, nil
+}
+
+// Invert returns the op that undoes o, given the document o applied to.
+func (o Op)Invert(base *Trie[byte]) Op {
+	var inv Op
+	at := 0
+	for _, c := range o.comps {
+		switch {
+		case c.Retain > 0:
+			inv.Retain(c.Retain)
+			at += c.Retain
+		case c.Delete > 0:
+			inv.Insert(textRange(base, at, at+c.Delete))
+			at += c.Delete
+		default:
+			inv.Delete(len(c.Insert))
+		}
+	}
+	return inv
+}
+
+// TransformIndex returns where offset i of the document o applies to ends
+// up. Text inserted at i goes in front of it, and an i that is deleted ends
+// up where the delete was.
+func (o Op)TransformIndex(i int) int {
+	out, left := i, i
+	for _, c := range o.comps {
+		switch {
+		case c.Retain > 0:
+			left -= c.Retain
+		case c.Delete > 0:
+			out -= min(left, c.Delete)
+			left -= c.Delete
+		default:
+			out += len(c.Insert)
+		}
+		if left < 0 {
+			break
+		}
+	}
+	return out
+}
+
+// op_reader hands out the components of an op a piece at a time, for
+// Compose and Transform, which walk two ops side by side
+type op_reader struct {
+	comps []Component
+	cur   Component // what is left of the current component
+}
+
+func (r *op_reader)next() {
+	if len(r.comps) == 0 {
+		r.cur = Component{}
+		return
+	}
+	r.cur, r.comps = r.comps[0], r.comps[1:]
+}
+
+func (r *op_reader)done() bool {
+	return r.cur.Retain == 0 && r.cur.Delete == 0 && r.cur.Insert == nil
+}
+
+// take uses up n bytes of the current component, which is a retain or a
+// delete of at least n
+func (r *op_reader)take(n int) {
+	if r.cur.Retain > 0 {
+		r.cur.Retain -= n
+	} else {
+		r.cur.Delete -= n
+	}
+	if r.cur.Retain == 0 && r.cur.Delete == 0 {
+		r.next()
+	}
+}
+
+func (r *op_reader)size() int {
+	if r.cur.Insert != nil {
+		return len(r.cur.Insert)
+	}
+	return r.cur.Retain + r.cur.Delete
+}
+
+// Compose returns the op that does a and then b.
+func Compose(a, b Op) (Op, error) {
+	if a.target != b.base {
+		return Op{}, ErrOpCompose
+	}
+	var out Op
+	ra, rb := &op_reader{comps: a.comps}, &op_reader{comps: b.comps}
+	ra.next()
+	rb.next()
+	for !ra.done() || !rb.done() {
+		switch {
+		case ra.cur.Delete > 0:
+			out.Delete(ra.cur.Delete)
+			ra.next()
+		case rb.cur.Insert != nil:
+			out.Insert(rb.cur.Insert)
+			rb.next()
+		case ra.done() || rb.done():
+			return Op{}, ErrOpCompose
+		default:
+			// a retains or inserts, b retains or deletes, over n bytes
+			n := min(ra.size(), rb.size())
+			switch {
+			case ra.cur.Retain > 0 && rb.cur.Retain > 0:
+				out.Retain(n)
+			case ra.cur.Retain > 0:
+				out.Delete(n)
+			case rb.cur.Retain > 0:
+				out.Insert(ra.cur.Insert[:n])
+			}
+			// an insert that b deletes never happened
+			if ra.cur.Insert != nil {
+				ra.cur.Insert = ra.cur.Insert[n:]
+				if len(ra.cur.Insert) == 0 {
+					ra.next()
+				}
+			} else {
+				ra.take(n)
+			}
+			rb.take(n)
+		}
+	}
+	return out, nil
+}
+
+func (o Op)MarshalJSON() ([]byte, error) {
+	out := make([]any, len(o.comps))
+	for i, c := range o.comps {
+		switch {
+		case c.Retain > 0:
+			out[i] = c.Retain
+		case c.Delete > 0:
+			out[i] = -c.Delete
+		default:
+			if !utf8.Valid(c.Insert) {
+				return nil, fmt.Errorf("%w: insert is not UTF-8", ErrOpEncoding)
+			}
+			out[i] = string(c.Insert)
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (o *Op)UnmarshalJSON(p []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(p, &raw); err != nil {
+		return err
+	}
+	*o = Op{}
+	for _, r := range raw {
+		var s string
+		var n int
+		switch {
+		case json.Unmarshal(r, &s) == nil:
+			o.Insert([]byte(s))
+		case json.Unmarshal(r, &n) == nil && n > 0:
+			o.Retain(n)
+		case n < 0:
+			o.Delete(-n)
+		default:
+			return fmt.Errorf("%w: %s", ErrOpEncoding, r)
+		}
+	}
+	return nil
+}
+
+// the binary form is a version byte, then each component as a uvarint of
+// its size shifted left by two and or'd with its kind, followed by the bytes
+// of an insert
+const (
+	op_binary_version = 1
+	op_retain         = 0
+	op_delete         = 1
+	op_insert         = 2
+)
+
+func (o Op)MarshalBinary() ([]byte, error) {
+	out := []byte{op_binary_version}
+	for _, c := range o.comps {
+		switch {
+		case c.Retain > 0:
+			out = binary.AppendUvarint(out, uint64(c.Retain)<<2|op_retain)
+		case c.Delete > 0:
+			out = binary.AppendUvarint(out, uint64(c.Delete)<<2|op_delete)
+		default:
+			out = binary.AppendUvarint(out, uint64(len(c.Insert))<<2|op_insert)
+			out = append(out, c.Insert...)
+		}
+	}
+	return out, nil
+}
+
+func (o *Op)UnmarshalBinary(p []byte) error {
+	if len(p) == 0 || p[0] != op_binary_version {
+		return fmt.Errorf("%w: unknown version", ErrOpEncoding)
+	}
+	*o = Op{}
+	p = p[1:]
+	for len(p) > 0 {
+		v, n := binary.Uvarint(p)
+		if n <= 0 || v>>2 == 0 || v>>2 > uint64(int(^uint(0)>>1)) {
+			return fmt.Errorf("%w: bad component", ErrOpEncoding)
+		}
+		p = p[n:]
+		size := int(v>>2)
+		switch v&3 {
+		case op_retain:
+			o.Retain(size)
+		case op_delete:
+			o.Delete(size)
+		case op_insert:
+			if size > len(p) {
+				return fmt.Errorf("%w: short insert", ErrOpEncoding)
+			}
+			o.Insert(p[:size])
+			p = p[size:]
+		default:
+			return fmt.Errorf("%w: bad component", ErrOpEncoding)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomOp makes an op for a document of size bytes
+func randomOp(rng *rand.Rand, size int) Op {
+	var o Op
+	for left := size; left > 0 || rng.Intn(3) == 0; {
+		switch n := 1 + rng.Intn(max(left, 1)); rng.Intn(3) {
+		case 0:
+			o.Retain(min(n, left))
+			left -= min(n, left)
+		case 1:
+			o.Delete(min(n, left))
+			left -= min(n, left)
+		default:
+			o.Insert([]byte("xyz\né"[:1+rng.Intn(6)]))
+		}
+		if left == 0 && rng.Intn(2) == 0 {
+			break
+		}
+	}
+	return o
+}
+
+func randomText(rng *rand.Rand, n int) *Trie[byte] {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = "abcdefgh\n"[rng.Intn(9)]
+	}
+	return TrieFromSlice[byte](p)
+}
+
+func apply(t *testing.T, o Op, text *Trie[byte]) *Trie[byte] {
+	t.Helper()
+	out, err := o.Apply(text)
+	if err != nil {
+		t.Fatalf("%v on %d bytes: %v", o, text.Size(), err)
+	}
+	return out
+}
+
+func trieString(t *Trie[byte]) string {
+	return string(Bytes(TrieBufferOf(t)))
+}
+
+func TestOpApply(t *testing.T) {
+	doc := TrieFromSlice[byte]([]byte("hello world"))
+	var o Op
+	o.Retain(6).Delete(5).Insert([]byte("there")).Insert([]byte("!"))
+	if o.String() != `retain 6 insert "there!" delete 5` {
+		t.Fatalf("not normalised: %v", o)
+	}
+	if o.BaseLen() != 11 || o.TargetLen() != 12 {
+		t.Fatalf("lengths %d, %d", o.BaseLen(), o.TargetLen())
+	}
+	if got := trieString(apply(t, o, doc)); got != "hello there!" {
+		t.Fatalf("Apply = %q", got)
+	}
+	if trieString(doc) != "hello world" {
+		t.Fatalf("Apply changed its argument")
+	}
+	if _, err := o.Apply(TrieFromSlice[byte]([]byte("hi"))); err != ErrOpLength {
+		t.Fatalf("Apply to the wrong size: %v", err)
+	}
+	if !ReplaceOp(3, 3, 0, nil).IsNoop() || ReplaceOp(3, 1, 1, nil).IsNoop() {
+		t.Fatalf("IsNoop")
+	}
+	if a, b := ReplaceOp(11, 6, 5, []byte("there!")), o; !reflect.DeepEqual(a, b) {
+		t.Fatalf("ReplaceOp = %v, want %v", a, b)
+	}
+}
+
+func TestEditsOp(t *testing.T) {
+	d := NewDocument(DefaultRegistry().Lookup("go").Grammar, []byte(swap_source))
+	v := d.Current()
+	at := bytes.Index([]byte(swap_source), []byte("b, c"))
+	e, _ := SwapSibling(v, at, at+1, true)
+	o := EditsOp(v.Text.Size(), e.Edits)
+	want, _ := d.Apply(v.Rev, e.Edits)
+	if got := trieString(apply(t, o, v.Text)); got != trieString(want.Text) {
+		t.Fatalf("EditsOp makes %q, want %q", got, trieString(want.Text))
+	}
+}
+
+func TestOpCompose(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		doc := randomText(rng, rng.Intn(40))
+		a := randomOp(rng, doc.Size())
+		after := apply(t, a, doc)
+		b := randomOp(rng, after.Size())
+		ab, err := Compose(a, b)
+		if err != nil {
+			t.Fatalf("Compose(%v, %v): %v", a, b, err)
+		}
+		if got, want := trieString(apply(t, ab, doc)), trieString(apply(t, b, after)); got != want {
+			t.Fatalf("Compose(%v, %v) = %v makes %q, want %q", a, b, ab, got, want)
+		}
+	}
+	if _, err := Compose(ReplaceOp(3, 0, 1, nil), ReplaceOp(3, 0, 1, nil)); err != ErrOpCompose {
+		t.Fatalf("Compose of ops that do not line up: %v", err)
+	}
+}
+
+func TestOpInvert(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 500; i++ {
+		doc := randomText(rng, rng.Intn(40))
+		o := randomOp(rng, doc.Size())
+		inv := o.Invert(doc)
+		if got := trieString(apply(t, inv, apply(t, o, doc))); got != trieString(doc) {
+			t.Fatalf("%v then its inverse %v makes %q of %q", o, inv, got, trieString(doc))
+		}
+		both, _ := Compose(o, inv)
+		if got := trieString(apply(t, both, doc)); got != trieString(doc) {
+			t.Fatalf("%v composed with its inverse is not a no-op", o)
+		}
+	}
+}
+
+func TestOpEncoding(t *testing.T) {
+	var o Op
+	o.Retain(3).Insert([]byte("é\"x")).Delete(2).Retain(1)
+	p, err := json.Marshal(o)
+	if err != nil || string(p) != `[3,"é\"x",-2,1]` {
+		t.Fatalf("MarshalJSON = %s, %v", p, err)
+	}
+	var back Op
+	if err := json.Unmarshal(p, &back); err != nil || !reflect.DeepEqual(back, o) {
+		t.Fatalf("UnmarshalJSON = %v, %v", back, err)
+	}
+	if err := json.Unmarshal([]byte(`[1,0]`), &back); !errors.Is(err, ErrOpEncoding) {
+		t.Fatalf("UnmarshalJSON of a zero: %v", err)
+	}
+	var bad Op
+	bad.Insert([]byte{0xff})
+	if _, err := json.Marshal(bad); err == nil {
+		t.Fatalf("MarshalJSON of an insert that is not UTF-8")
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 200; i++ {
+		o := randomOp(rng, rng.Intn(100))
+		p, _ := o.MarshalBinary()
+		var back Op
+		if err := back.UnmarshalBinary(p); err != nil || !reflect.DeepEqual(back, o) {
+			t.Fatalf("binary round trip of %v = %v, %v", o, back, err)
+		}
+		if len(p) > 1 {
+			if err := back.UnmarshalBinary(p[:len(p)-1]); err == nil && reflect.DeepEqual(back, o) {
+				t.Fatalf("a cut short %v decoded", o)
+			}
+		}
+	}
+	if err := back.UnmarshalBinary([]byte{9}); !errors.Is(err, ErrOpEncoding) {
+		t.Fatalf("UnmarshalBinary of a bad version: %v", err)
+	}
+}
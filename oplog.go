@@ -0,0 +1,389 @@
+/* OpLog is an append-only file of the revisions of a document, so what was
+   typed survives the server going down. The file is a header and then one
+   record per revision:
+	length  uint32, little endian, of the payload
+	crc     uint32, Castagnoli, of the payload
+	hcrc    uint32, Castagnoli, of length and crc
+	payload rev, then the length of the client and the client, as uvarints,
+	        then the op in its binary form
+   A record is only written whole, but a crash can still leave the last one
+   torn: short, or not matching its checksum as its bytes never made it to
+   the disk. Opening a log checks every record and cuts such a last record
+   off, so appends go after the last good one. The length has a checksum of
+   its own, so a bad one is not taken for a short record: a record is only
+   torn if its header is short, or all zeros as blocks that were never
+   written read, or good with a payload that runs past the end or is the
+   last thing in the file. Anything else bad is no crash, and opening the
+   log fails with ErrLogCorrupt rather than throw away the good records
+   after it.
+   How often the file is synced is up to the SyncPolicy. SyncAlways syncs
+   every record before Append returns, SyncEvery at most every Interval, so
+   a crash loses at most that much, and SyncNever leaves it to the OS.
+ */
+package web
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLogFormat  = errors.New("web: not an op log")
+	ErrLogClosed  = errors.New("web: op log is closed")
+	ErrLogCorrupt = errors.New("web: op log is corrupt")
+)
+
+const (
+	log_header        = "web oplog 1\n"
+	log_record_header = 12
+	log_record_max    = 1<<30
+	log_sync_interval = 100*time.Millisecond
+)
+
+var log_crc = crc32.MakeTable(crc32.Castagnoli)
+
+type SyncPolicy int
+
+const (
+	SyncAlways SyncPolicy = iota
+	SyncEvery
+	SyncNever
+)
+
+type LogOptions struct {
+	Sync SyncPolicy
+	// Interval is how often SyncEvery syncs, 100ms if it is not set
+	Interval time.Duration
+}
+
+type OpLog struct {
+	opts   LogOptions
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	last   int // the revision of the last record, 0 when there are none
+	dirty  bool
+	err    error // the first failed sync of SyncEvery
+	closed bool
+	done   chan struct{}
+}
+
+// OpenOpLog opens the log at path, or makes it, and cuts off a torn tail.
+func OpenOpLog(path string, opts LogOptions) (*OpLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = log_sync_interval
+	}
+	l := &OpLog{opts: opts, f: f}
+	if err := l.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if opts.Sync == SyncEvery {
+		l.done = make(chan struct{})
+		go l.sync_every()
+	}
+	return l, nil
+}
+
+func (l *OpLog)recover() error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < int64(len(log_header)) {
+		// new, or torn before the header was all there
+		head := make([]byte, info.Size())
+		if _, err := l.f.ReadAt(head, 0); err != nil || string(head) != log_header[:len(head)] {
+			return ErrLogFormat
+		}
+		if _, err := l.f.WriteAt([]byte(log_header), 0); err != nil {
+			return err
+		}
+		l.size = int64(len(log_header))
+		if _, err := l.f.Seek(l.size, io.SeekStart); err != nil {
+			return err
+		}
+		return l.f.Sync()
+	}
+	end, err := scan_log(l.f, info.Size(), func(r Revision) error {
+		l.last = r.Rev
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if end < info.Size() {
+		if err := l.f.Truncate(end); err != nil {
+			return err
+		}
+		if err := l.f.Sync(); err != nil {
+			return err
+		}
+	}
+	l.size = end
+	_, err = l.f.Seek(end, io.SeekStart)
+	return err
+}
+
+// scan_log calls f with every good record of the log in the first size
+// bytes of r, and returns where they end. Only a torn last record may follow
+// them, anything else bad is ErrLogCorrupt.
+func scan_log(r io.ReaderAt, size int64, f func(Revision) error) (int64, error) {
+	br := bufio.NewReader(io.NewSectionReader(r, 0, size))
+	header := make([]byte, len(log_header))
+	if _, err := io.ReadFull(br, header); err != nil || string(header) != log_header {
+		return 0, ErrLogFormat
+	}
+	end := int64(len(log_header))
+	var head [log_record_header]byte
+	for {
+		left := size-end-log_record_header
+		if left < 0 {
+			// the end, or a record header cut short
+			return end, nil
+		}
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			return end, err
+		}
+		if crc32.Checksum(head[:8], log_crc) != binary.LittleEndian.Uint32(head[8:]) {
+			if zeros, err := all_zeros(head[:], br); err != nil || zeros {
+				return end, err
+			}
+			return end, fmt.Errorf("%w: bad record header at %d", ErrLogCorrupt, end)
+		}
+		n := int64(binary.LittleEndian.Uint32(head[:4]))
+		if n > left {
+			// a record cut short, which only the last one can be
+			return end, nil
+		}
+		if n > log_record_max {
+			return end, fmt.Errorf("%w: record at %d is too big", ErrLogCorrupt, end)
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return end, err
+		}
+		if crc32.Checksum(payload, log_crc) != binary.LittleEndian.Uint32(head[4:]) {
+			if n == left {
+				// the last record, not all on the disk
+				return end, nil
+			}
+			return end, fmt.Errorf("%w: bad checksum at %d", ErrLogCorrupt, end)
+		}
+		rev, err := decode_revision(payload)
+		if err != nil {
+			return end, fmt.Errorf("%w: bad record at %d", ErrLogCorrupt, end)
+		}
+		if err := f(rev); err != nil {
+			return end, err
+		}
+		end += log_record_header+n
+	}
+}
+
+// all_zeros returns whether head and the rest of r are all zeros
+func all_zeros(head []byte, r io.Reader) (bool, error) {
+	buf := make([]byte, 4096)
+	for p := head; ; {
+		for _, b := range p {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		n, err := r.Read(buf)
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		p = buf[:n]
+	}
+}
+
+func encode_revision(r Revision) ([]byte, error) {
+	op, err := r.Op.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	p := binary.AppendUvarint(nil, uint64(r.Rev))
+	p = binary.AppendUvarint(p, uint64(len(r.Client)))
+	p = append(p, r.Client...)
+	return append(p, op...), nil
+}
+
+func decode_revision(p []byte) (Revision, error) {
+	var r Revision
+	rev, n := binary.Uvarint(p)
+	if n <= 0 {
+		return r, ErrLogFormat
+	}
+	p = p[n:]
+	size, n := binary.Uvarint(p)
+	if n <= 0 || size > uint64(len(p)-n) {
+		return r, ErrLogFormat
+	}
+	p = p[n:]
+	r.Rev, r.Client = int(rev), string(p[:size])
+	err := r.Op.UnmarshalBinary(p[size:])
+	return r, err
+}
+
+// Append writes r to the end of the log, and syncs it if the policy says so.
+func (l *OpLog)Append(r Revision) error {
+	payload, err := encode_revision(r)
+	if err != nil {
+		return err
+	}
+	if len(payload) > log_record_max {
+		return fmt.Errorf("web: revision %d is too big to log", r.Rev)
+	}
+	rec := make([]byte, log_record_header, log_record_header+len(payload))
+	binary.LittleEndian.PutUint32(rec[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(rec[4:], crc32.Checksum(payload, log_crc))
+	binary.LittleEndian.PutUint32(rec[8:], crc32.Checksum(rec[:8], log_crc))
+	rec = append(rec, payload...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+	if l.err != nil {
+		return l.err
+	}
+	if _, err := l.f.Write(rec); err != nil {
+		// leave no half record behind for the next append to follow
+		l.f.Truncate(l.size)
+		l.f.Seek(l.size, io.SeekStart)
+		return err
+	}
+	l.size += int64(len(rec))
+	l.last = r.Rev
+	l.dirty = true
+	if l.opts.Sync == SyncAlways {
+		return l.sync()
+	}
+	return nil
+}
+
+// Last returns the revision of the last record, 0 when there are none.
+func (l *OpLog)Last() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// each calls f with every record, up to the last one written when it starts
+func (l *OpLog)each(f func(Revision) error) error {
+	l.mu.Lock()
+	size := l.size
+	l.mu.Unlock()
+	_, err := scan_log(l.f, size, f)
+	return err
+}
+
+// Replay applies the revisions after rev in the log to text, which is the
+// document at rev, and returns the document at the last one.
+func (l *OpLog)Replay(text *Trie[byte], rev int) (*Trie[byte], int, error) {
+	err := l.each(func(r Revision) error {
+		if r.Rev <= rev {
+			return nil
+		}
+		if r.Rev != rev+1 {
+			return ErrRevision
+		}
+		next, err := r.Op.Apply(text)
+		if err != nil {
+			return err
+		}
+		text, rev = next, r.Rev
+		return nil
+	})
+	return text, rev, err
+}
+
+// RecoverOpLog opens the log at path after a crash, and replays it onto
+// text, the last snapshot of the document, which was at rev.
+func RecoverOpLog(path string, opts LogOptions, text *Trie[byte], rev int) (*OpLog, *Trie[byte], int, error) {
+	l, err := OpenOpLog(path, opts)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if text, rev, err = l.Replay(text, rev); err != nil {
+		l.Close()
+		return nil, nil, 0, err
+	}
+	return l, text, rev, nil
+}
+
+func (l *OpLog)sync() error {
+	if !l.dirty {
+		return nil
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// Sync syncs the log now, whatever the policy.
+func (l *OpLog)Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+	return l.sync()
+}
+
+func (l *OpLog)sync_every() {
+	t := time.NewTicker(l.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-t.C:
+			l.mu.Lock()
+			if err := l.sync(); err != nil && l.err == nil {
+				l.err = err
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// Close syncs the log, unless the policy is SyncNever, and closes it.
+func (l *OpLog)Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrLogClosed
+	}
+	l.closed = true
+	var err error
+	if l.opts.Sync != SyncNever {
+		err = l.sync()
+	}
+	l.mu.Unlock()
+	if l.done != nil {
+		close(l.done)
+	}
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
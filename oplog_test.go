@@ -0,0 +1,168 @@
+package web
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// logRevisions runs n random ops through a session that logs them to l, and
+// returns the text it started and ended with
+func logRevisions(t *testing.T, l *OpLog, n int) (*Trie[byte], *Trie[byte]) {
+	rng := rand.New(rand.NewSource(1))
+	start := randomText(rng, 40)
+	s := NewSession(start)
+	s.Subscribe(func(r Revision) {
+		if err := l.Append(r); err != nil {
+			t.Error(err)
+		}
+	})
+	for i := 0; i < n; i++ {
+		text, rev := s.Snapshot()
+		if _, err := s.Receive("c", rev, randomOp(rng, text.Size())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	end, _ := s.Snapshot()
+	return start, end
+}
+
+func TestOpLogReplay(t *testing.T) {
+	for _, sync := range []SyncPolicy{SyncAlways, SyncEvery, SyncNever} {
+		path := filepath.Join(t.TempDir(), "page.log")
+		l, err := OpenOpLog(path, LogOptions{Sync: sync, Interval: time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, end := logRevisions(t, l, 50)
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Append(Revision{}); err != ErrLogClosed {
+			t.Fatalf("Append after Close: %v", err)
+		}
+
+		l, text, rev, err := RecoverOpLog(path, LogOptions{Sync: sync}, start, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rev != 50 || l.Last() != 50 || trieString(text) != trieString(end) {
+			t.Fatalf("policy %d: replayed to %d, %q, want %q", sync, rev, trieString(text), trieString(end))
+		}
+		// replaying onto a later snapshot only applies what comes after it
+		if again, rev, _ := l.Replay(end, 50); rev != 50 || again != end {
+			t.Fatalf("replay past the end went to %d", rev)
+		}
+		l.Close()
+	}
+}
+
+func TestOpLogTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "page.log")
+	l, _ := OpenOpLog(path, LogOptions{Sync: SyncNever})
+	start, _ := logRevisions(t, l, 10)
+	l.Close()
+	info, _ := os.Stat(path)
+	good := info.Size()
+
+	// a record cut short, a record with a bad checksum, blocks never
+	// written and a header cut short
+	for _, tail := range [][]byte{
+		append(recordHeader(40, 0), 1, 2, 3, 4, 5),
+		append(recordHeader(1, 0), 7),
+		make([]byte, 100),
+		{1},
+	} {
+		f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		f.Write(tail)
+		f.Close()
+		l, text, rev, err := RecoverOpLog(path, LogOptions{}, start, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info, _ := os.Stat(path); info.Size() != good || rev != 10 {
+			t.Fatalf("torn tail %v: size %d, want %d, rev %d", tail, info.Size(), good, rev)
+		}
+		// appends go after the last good record
+		op := ReplaceOp(text.Size(), 0, 0, []byte("!"))
+		if err := l.Append(Revision{11, "c", op}); err != nil {
+			t.Fatal(err)
+		}
+		l.Close()
+		l, after, rev, _ := RecoverOpLog(path, LogOptions{}, start, 0)
+		if rev != 11 || trieString(after) != "!"+trieString(text) {
+			t.Fatalf("append after recovery replayed to %d", rev)
+		}
+		l.Close()
+		os.Truncate(path, good)
+	}
+}
+
+func TestOpLogFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "page.log")
+	os.WriteFile(path, []byte("not a log at all"), 0o644)
+	if _, err := OpenOpLog(path, LogOptions{}); err != ErrLogFormat {
+		t.Fatalf("OpenOpLog of something else: %v", err)
+	}
+	// a header torn while the log was made is finished
+	os.WriteFile(path, []byte(log_header[:4]), 0o644)
+	l, err := OpenOpLog(path, LogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if p, _ := os.ReadFile(path); string(p) != log_header {
+		t.Fatalf("log is %q", p)
+	}
+}
+
+// recordHeader is the header of a record of n bytes with checksum crc
+func recordHeader(n int, crc uint32) []byte {
+	h := binary.LittleEndian.AppendUint32(nil, uint32(n))
+	h = binary.LittleEndian.AppendUint32(h, crc)
+	return binary.LittleEndian.AppendUint32(h, crc32.Checksum(h, log_crc))
+}
+
+// a bad record with good ones after it is no torn tail, and is left alone
+func TestOpLogCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "page.log")
+	l, _ := OpenOpLog(path, LogOptions{Sync: SyncNever})
+	logRevisions(t, l, 10)
+	l.Close()
+	p, _ := os.ReadFile(path)
+	// the last byte of the payload of the first record
+	first := len(log_header)+log_record_header
+	first += int(p[len(log_header)]) - 1
+	p[first] ^= 0xff
+	os.WriteFile(path, p, 0o644)
+	if _, err := OpenOpLog(path, LogOptions{}); !errors.Is(err, ErrLogCorrupt) {
+		t.Fatalf("OpenOpLog of a log with a bad first record: %v", err)
+	}
+	if after, _ := os.ReadFile(path); len(after) != len(p) {
+		t.Fatalf("the log was cut to %d bytes from %d", len(after), len(p))
+	}
+}
+
+// a length gone bad in the middle of the log is not taken for a record cut
+// short, which would throw away the ones after it
+func TestOpLogCorruptLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "page.log")
+	l, _ := OpenOpLog(path, LogOptions{Sync: SyncNever})
+	logRevisions(t, l, 10)
+	l.Close()
+	p, _ := os.ReadFile(path)
+	second := len(log_header)+log_record_header+int(binary.LittleEndian.Uint32(p[len(log_header):]))
+	binary.LittleEndian.PutUint32(p[second:], 1<<20)
+	os.WriteFile(path, p, 0o644)
+	if _, err := OpenOpLog(path, LogOptions{}); !errors.Is(err, ErrLogCorrupt) {
+		t.Fatalf("OpenOpLog of a log with a bad length: %v", err)
+	}
+	if after, _ := os.ReadFile(path); len(after) != len(p) {
+		t.Fatalf("the log was cut to %d bytes from %d", len(after), len(p))
+	}
+}
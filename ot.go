@@ -0,0 +1,175 @@
+/* Operational transformation lets several people edit one document at once.
+   Each client sends its ops along with the revision it made them on. The
+   Session holds the one true text. It transforms an op from a client past
+   every op accepted since its revision, applies the result as the next
+   revision and sends it to everyone, the client that made it included, so
+   it knows it was taken.
+   Transform(a, b) gives a' and b' such that a then b' and b then a' end up
+   at the same text, TP1. When both insert at the same place a's text goes
+   first. The session always passes what it has already accepted as a, and
+   clients must do the same with the ops they get from it, as Client does.
+ */
+package web
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrOpTransform = errors.New("web: ops are not on the same document")
+	ErrRevision    = errors.New("web: no such revision")
+)
+
+// Transform returns a' and b', which do a and b after the other one was
+// done, for a and b made on the same document.
+func Transform(a, b Op) (Op, Op, error) {
+	if a.base != b.base {
+		return Op{}, Op{}, ErrOpTransform
+	}
+	var a1, b1 Op
+	ra, rb := &op_reader{comps: a.comps}, &op_reader{comps: b.comps}
+	ra.next()
+	rb.next()
+	for !ra.done() || !rb.done() {
+		switch {
+		case ra.cur.Insert != nil:
+			a1.Insert(ra.cur.Insert)
+			b1.Retain(len(ra.cur.Insert))
+			ra.next()
+		case rb.cur.Insert != nil:
+			a1.Retain(len(rb.cur.Insert))
+			b1.Insert(rb.cur.Insert)
+			rb.next()
+		case ra.done() || rb.done():
+			return Op{}, Op{}, ErrOpTransform
+		default:
+			n := min(ra.size(), rb.size())
+			switch {
+			case ra.cur.Retain > 0 && rb.cur.Retain > 0:
+				a1.Retain(n)
+				b1.Retain(n)
+			case ra.cur.Delete > 0 && rb.cur.Retain > 0:
+				a1.Delete(n)
+			case ra.cur.Retain > 0 && rb.cur.Delete > 0:
+				b1.Delete(n)
+			}
+			// and when both delete, it is gone for both
+			ra.take(n)
+			rb.take(n)
+		}
+	}
+	return a1, b1, nil
+}
+
+// Revision is an op the session accepted, which made revision Rev.
+type Revision struct {
+	Rev    int    `json:"rev"`
+	Client string `json:"client"`
+	Op     Op     `json:"op"`
+}
+
+type Session struct {
+	mu  sync.Mutex
+	buf *SharedBuffer
+	// history[i] made revision first+i+1
+	history []Revision
+	first   int
+	subs    map[int]func(Revision)
+	next    int
+}
+
+// NewSession starts a session on text, as revision 0.
+func NewSession(text *Trie[byte]) *Session {
+	return NewSessionAt(text, 0)
+}
+
+// NewSessionAt starts a session on text as revision rev, for a document that
+// was loaded from storage.
+func NewSessionAt(text *Trie[byte], rev int) *Session {
+	return &Session{buf: NewSharedBufferAt(text, rev), first: rev, subs: map[int]func(Revision){}}
+}
+
+// Snapshot returns the current text and its revision, without waiting for
+// writers.
+func (s *Session)Snapshot() (*Trie[byte], int) {
+	return s.buf.Snapshot()
+}
+
+// Receive takes op from client, made on revision base, and returns it as it
+// was applied, with the revision it made.
+func (s *Session)Receive(client string, base int, op Op) (Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	text, rev := s.buf.Snapshot()
+	if base < s.first || base > rev {
+		return Revision{}, ErrRevision
+	}
+	for _, h := range s.history[base-s.first:] {
+		var err error
+		if _, op, err = Transform(h.Op, op); err != nil {
+			return Revision{}, err
+		}
+	}
+	next, err := op.Apply(text)
+	if err != nil {
+		return Revision{}, err
+	}
+	s.buf.Replace(rev, next)
+	r := Revision{rev+1, client, op}
+	s.history = append(s.history, r)
+	for _, f := range s.subscribers() {
+		f(r)
+	}
+	return r, nil
+}
+
+func (s *Session)subscribers() []func(Revision) {
+	out := make([]func(Revision), 0, len(s.subs))
+	for _, f := range s.subs {
+		out = append(out, f)
+	}
+	return out
+}
+
+// Since returns the ops that made the revisions after rev, for a client that
+// is catching up.
+func (s *Session)Since(rev int) ([]Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, cur := s.buf.Snapshot()
+	if rev < s.first || rev > cur {
+		return nil, ErrRevision
+	}
+	return append([]Revision(nil), s.history[rev-s.first:]...), nil
+}
+
+// Forget drops the history up to rev. Clients on an older revision have to
+// start over from a snapshot.
+func (s *Session)Forget(rev int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, cur := s.buf.Snapshot()
+	rev = min(rev, cur)
+	if rev <= s.first {
+		return
+	}
+	s.history = append([]Revision(nil), s.history[rev-s.first:]...)
+	s.first = rev
+}
+
+// Subscribe calls f with every revision from now on, in order, until cancel
+// is called. f is called with the session locked, so it must hand the
+// revision off rather than call back into the session.
+func (s *Session)Subscribe(f func(Revision)) (cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.next
+	s.next++
+	s.subs[id] = f
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subs, id)
+	}
+}
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestTransformTP1(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		doc := randomText(rng, rng.Intn(30))
+		a, b := randomOp(rng, doc.Size()), randomOp(rng, doc.Size())
+		a1, b1, err := Transform(a, b)
+		if err != nil {
+			t.Fatalf("Transform(%v, %v): %v", a, b, err)
+		}
+		ab := trieString(apply(t, b1, apply(t, a, doc)))
+		ba := trieString(apply(t, a1, apply(t, b, doc)))
+		if ab != ba {
+			t.Fatalf("Transform(%v, %v) = %v, %v: %q and %q", a, b, a1, b1, ab, ba)
+		}
+	}
+	if _, _, err := Transform(ReplaceOp(2, 0, 0, nil), ReplaceOp(3, 0, 0, nil)); err != ErrOpTransform {
+		t.Fatalf("Transform of ops on different documents: %v", err)
+	}
+}
+
+func TestTransformTies(t *testing.T) {
+	doc := TrieFromSlice[byte]([]byte("ac"))
+	a, b := ReplaceOp(2, 1, 0, []byte("A")), ReplaceOp(2, 1, 0, []byte("B"))
+	a1, b1, _ := Transform(a, b)
+	if got := trieString(apply(t, b1, apply(t, a, doc))); got != "aABc" {
+		t.Fatalf("a's insert should go first, got %q", got)
+	}
+	if got := trieString(apply(t, a1, apply(t, b, doc))); got != "aABc" {
+		t.Fatalf("a's insert should go first, got %q", got)
+	}
+
+	// an insert inside a range the other deletes survives it
+	a, b = ReplaceOp(2, 1, 0, []byte("X")), ReplaceOp(2, 0, 2, nil)
+	a1, b1, _ = Transform(a, b)
+	if got := trieString(apply(t, a1, apply(t, b, doc))); got != "X" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestSession(t *testing.T) {
+	s := NewSession(TrieFromSlice[byte]([]byte("hello")))
+	var seen []Revision
+	cancel := s.Subscribe(func(r Revision) { seen = append(seen, r) })
+	defer cancel()
+
+	// two clients edit revision 0 at once
+	if _, err := s.Receive("a", 0, ReplaceOp(5, 5, 0, []byte(" world"))); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Receive("b", 0, ReplaceOp(5, 0, 1, []byte("J")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Rev != 2 || r.Op.BaseLen() != 11 {
+		t.Fatalf("b's op came out as %+v", r)
+	}
+	text, rev := s.Snapshot()
+	if trieString(text) != "Jello world" || rev != 2 {
+		t.Fatalf("Snapshot = %q, %d", trieString(text), rev)
+	}
+	if len(seen) != 2 || seen[0].Client != "a" || seen[1].Client != "b" {
+		t.Fatalf("broadcast %v", seen)
+	}
+
+	since, _ := s.Since(1)
+	if len(since) != 1 || since[0].Rev != 2 {
+		t.Fatalf("Since(1) = %v", since)
+	}
+	if _, err := s.Receive("c", 3, ReplaceOp(11, 0, 0, nil)); err != ErrRevision {
+		t.Fatalf("op on a future revision: %v", err)
+	}
+	s.Forget(2)
+	if _, err := s.Receive("c", 1, ReplaceOp(5, 0, 0, nil)); err != ErrRevision {
+		t.Fatalf("op on a forgotten revision: %v", err)
+	}
+}
+
+// clients that each know some revision make ops on it at once, and everyone
+// ends up with the session's text by replaying the broadcast
+func TestSessionConcurrent(t *testing.T) {
+	start := randomText(rand.New(rand.NewSource(0)), 50)
+	s := NewSession(start)
+	var mu sync.Mutex
+	var log []Revision
+	s.Subscribe(func(r Revision) {
+		mu.Lock()
+		log = append(log, r)
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for c := 0; c < 8; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(c)))
+			for i := 0; i < 50; i++ {
+				text, rev := s.Snapshot()
+				if _, err := s.Receive(fmt.Sprint(c), rev, randomOp(rng, text.Size())); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	text := start
+	for i, r := range log {
+		if r.Rev != i+1 {
+			t.Fatalf("revision %d broadcast as %d", i+1, r.Rev)
+		}
+		text = apply(t, r.Op, text)
+	}
+	if final, _ := s.Snapshot(); trieString(final) != trieString(text) {
+		t.Fatalf("replaying the broadcast does not give the session's text")
+	}
+}
@@ -0,0 +1,123 @@
+/* Folding, the outline and selecting by syntax node are all read off the
+   parse tree of a version, for the "easy click and find" of the notes.
+   + Folds runs the language's fold query, one fold per first line.
+   + Outline nests the symbols of the index by where they are, so a method
+     ends up under its class and a subheading under its heading.
+   + Enclosing grows a selection to the smallest node around it.
+ */
+package web
+
+import (
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+type FoldRange struct {
+	// Kind is what the fold query captured the node as after "fold.", empty
+	// for plain @fold, "comment" or "imports".
+	Kind  string
+	Range sitter.Range
+	// the lines to fold, the first one stays visible. a node that ends at
+	// the start of a line, like a Markdown section, ends on the line before
+	StartRow, EndRow uint32
+}
+
+// Folds returns the folds of v in lang, ordered by their first line. Nested
+// nodes that start on the same line fold together as the outermost one, and
+// nodes on one line do not fold.
+func Folds(lang *Language, v *Version) []FoldRange {
+	if lang == nil || v.Tree == nil {
+		return nil
+	}
+	q := lang.query(lang.Folds)
+	if q == nil {
+		return nil
+	}
+	tree := v.Tree.Copy()
+	qc := sitter.NewQueryCursor()
+	defer qc.Close()
+	qc.Exec(q, tree.RootNode())
+
+	byrow := map[uint32]FoldRange{}
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		for _, c := range m.Captures {
+			r := c.Node.Range()
+			f := FoldRange{Range: r, StartRow: r.StartPoint.Row, EndRow: r.EndPoint.Row}
+			if r.EndPoint.Column == 0 && f.EndRow > f.StartRow {
+				f.EndRow--
+			}
+			if f.EndRow <= f.StartRow {
+				continue
+			}
+			name := q.CaptureNameForId(c.Index)
+			f.Kind, _ = strings.CutPrefix(strings.TrimPrefix(name, "fold"), ".")
+			if have, ok := byrow[f.StartRow]; !ok || f.EndRow > have.EndRow {
+				byrow[f.StartRow] = f
+			}
+		}
+	}
+	out := make([]FoldRange, 0, len(byrow))
+	for _, f := range byrow {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].StartRow < out[j].StartRow
+	})
+	return out
+}
+
+type OutlineItem struct {
+	Symbol
+	Children []*OutlineItem
+}
+
+// Outline returns the symbols of the file at path as a tree, each under the
+// innermost symbol whose definition holds it.
+func (ix *Index)Outline(path string) []*OutlineItem {
+	syms := ix.Symbols(path)
+	sort.SliceStable(syms, func(i, j int) bool {
+		if syms[i].Range.StartByte != syms[j].Range.StartByte {
+			return syms[i].Range.StartByte < syms[j].Range.StartByte
+		}
+		return syms[i].Range.EndByte > syms[j].Range.EndByte
+	})
+	var top []*OutlineItem
+	var open []*OutlineItem // the items that can still get children
+	for _, s := range syms {
+		item := &OutlineItem{Symbol: s}
+		for len(open) > 0 && open[len(open)-1].Range.EndByte <= s.Range.StartByte {
+			open = open[:len(open)-1]
+		}
+		if len(open) == 0 {
+			top = append(top, item)
+		} else {
+			parent := open[len(open)-1]
+			parent.Children = append(parent.Children, item)
+		}
+		open = append(open, item)
+	}
+	return top
+}
+
+// Enclosing returns the range of the smallest named node of v that holds the
+// selection from start to end and is bigger than it, which is what a
+// selection grows to. It returns false when the selection is the whole tree.
+func Enclosing(v *Version, start, end int) (sitter.Range, bool) {
+	if v.Tree == nil {
+		return sitter.Range{}, false
+	}
+	n := node_for(v, v.Tree.Copy(), start, end)
+	for n != nil && int(n.StartByte()) == start && int(n.EndByte()) == end {
+		n = n.Parent()
+	}
+	if n == nil {
+		return sitter.Range{}, false
+	}
+	return n.Range(), true
+}
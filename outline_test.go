@@ -0,0 +1,91 @@
+package web
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFoldQueries(t *testing.T) {
+	for _, l := range builtin_languages() {
+		if l.Folds != "" && l.query(l.Folds) == nil {
+			t.Errorf("%s: no fold query", l.Name)
+		}
+	}
+}
+
+func TestFolds(t *testing.T) {
+	r := DefaultRegistry()
+	d, l := r.Open("main.go", []byte(go_source))
+	var got [][3]any
+	for _, f := range Folds(l, d.Current()) {
+		got = append(got, [3]any{f.Kind, f.StartRow, f.EndRow})
+	}
+	// the one line struct does not fold, and the bodies fold with their
+	// functions
+	want := [][3]any{{"", uint32(6), uint32(8)}, {"", uint32(10), uint32(12)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Folds = %v, want %v", got, want)
+	}
+
+	page := "# A\n\ntext\n\n## B\n\n- x\n- y\n\n# C\nfoo\n"
+	d, md := r.Open("page.md", []byte(page))
+	got = nil
+	for _, f := range Folds(md, d.Current()) {
+		got = append(got, [3]any{f.Kind, f.StartRow, f.EndRow})
+	}
+	want = [][3]any{{"", uint32(0), uint32(8)}, {"", uint32(4), uint32(8)},
+		{"", uint32(6), uint32(8)}, {"", uint32(9), uint32(10)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Markdown Folds = %v, want %v", got, want)
+	}
+}
+
+func outline(items []*OutlineItem) string {
+	var parts []string
+	for _, it := range items {
+		s := it.Name
+		if len(it.Children) > 0 {
+			s += "(" + outline(it.Children) + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestOutline(t *testing.T) {
+	r := DefaultRegistry()
+	ix := NewIndex(r)
+	page := "# Intro\n\n## Setup\n\n```py\nclass A:\n    def f(self):\n        pass\n```\n\n" +
+		"## Usage\n\ntext\n\n# Reference\n"
+	d, md := r.Open("page.md", []byte(page))
+	ix.Update("page.md", md, d.Current())
+	if got, want := outline(ix.Outline("page.md")), "Intro(Setup(A(f)) Usage) Reference"; got != want {
+		t.Fatalf("Outline = %s, want %s", got, want)
+	}
+}
+
+func TestEnclosing(t *testing.T) {
+	r := DefaultRegistry()
+	d, _ := r.Open("main.go", []byte(go_source))
+	v := d.Current()
+	at := strings.Index(go_source, "q.X")
+	var got []string
+	start, end := at, at
+	for {
+		rng, ok := Enclosing(v, start, end)
+		if !ok {
+			break
+		}
+		start, end = int(rng.StartByte), int(rng.EndByte)
+		got = append(got, go_source[start:end])
+	}
+	want := []string{"q", "q.X", "p.X + q.X", "{p.X + q.X, p.Y + q.Y}",
+		"Point{p.X + q.X, p.Y + q.Y}", "return Point{p.X + q.X, p.Y + q.Y}"}
+	if !reflect.DeepEqual(got[:len(want)], want) {
+		t.Fatalf("Enclosing grows through\n%q\nwant\n%q", got, want)
+	}
+	if got[len(got)-1] != go_source {
+		t.Fatalf("Enclosing stops at %q", got[len(got)-1])
+	}
+}
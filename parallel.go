@@ -0,0 +1,204 @@
+/* Bulk operations that spread a trie over several goroutines.
+   The leaves of a trie are independent [m]T arrays, so counting lines,
+   hashing, searching and mapping can all be done a subtrie at a time. We
+   split the trie at subtrie boundaries until there are a few pieces per
+   worker, hand the pieces to a pool of workers and put the results back
+   together in order.
+ */
+package web
+
+import (
+	"runtime"
+	"sync"
+)
+
+// pieces handed out per worker, so a slow piece does not stall the others
+const pieces_per_worker = 4
+
+// a subtrie and the index of its first element in the whole trie
+type piece[T any] struct {
+	t      *Trie[T]
+	offset int
+}
+
+func workers_or_default(workers int) int {
+	if workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return workers
+}
+
+// eachLeaf calls f on every leaf under t in order, with the index of the
+// leaf's first element. It stops early if f returns false.
+func (t *Trie[T])eachLeaf(offset int, f func(offset int, leaf []T) bool) bool {
+	if t.height == 0 {
+		if t.length == 0 {
+			return true
+		}
+		return f(offset, t.content[:t.length])
+	}
+	start := offset
+	for i := 0; i < t.length; i++ {
+		if !t.subtrie[i].eachLeaf(start, f) {
+			return false
+		}
+		start = offset + t.subsize[i]
+	}
+	return true
+}
+
+// split breaks t into at least parts pieces, in order, by going down one
+// level at a time. It stops early when it reaches the leaves.
+func split[T any](t *Trie[T], parts int) []piece[T] {
+	pieces := []piece[T]{{t, 0}}
+	for len(pieces) < parts {
+		next := make([]piece[T], 0, len(pieces)*m)
+		grew := false
+		for _, p := range pieces {
+			if p.t.height == 0 {
+				next = append(next, p)
+				continue
+			}
+			grew = true
+			start := p.offset
+			for i := 0; i < p.t.length; i++ {
+				next = append(next, piece[T]{p.t.subtrie[i], start})
+				start = p.offset + p.t.subsize[i]
+			}
+		}
+		pieces = next
+		if !grew {
+			break
+		}
+	}
+	return pieces
+}
+
+// run calls f(i) for every i in [0,n) on a pool of workers
+func run(n, workers int, f func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// ParallelForEachLeaf calls f on every leaf of t from a pool of workers. Leaves
+// are visited concurrently and in no particular order; offset is the index
+// of the leaf's first element. f must not modify the leaf.
+// workers <= 0 uses GOMAXPROCS.
+func ParallelForEachLeaf[T any](t *Trie[T], workers int, f func(offset int, leaf []T)) {
+	workers = workers_or_default(workers)
+	pieces := split(t, workers*pieces_per_worker)
+	run(len(pieces), workers, func(i int) {
+		pieces[i].t.eachLeaf(pieces[i].offset, func(offset int, leaf []T) bool {
+			f(offset, leaf)
+			return true
+		})
+	})
+}
+
+// ParallelFold folds every piece of t with fold, starting from init, and then
+// merges the results from left to right. init has to be an identity for
+// merge, and merge has to be associative, e.g. counting lines:
+//
+//	ParallelFold(t, 0, 0,
+//		func(n int, leaf []byte) int { return n + bytes.Count(leaf, nl) },
+//		func(a, b int) int { return a + b })
+func ParallelFold[T, A any](t *Trie[T], workers int, init A,
+	fold func(acc A, leaf []T) A, merge func(l, r A) A) A {
+	workers = workers_or_default(workers)
+	pieces := split(t, workers*pieces_per_worker)
+	results := make([]A, len(pieces))
+	run(len(pieces), workers, func(i int) {
+		acc := init
+		pieces[i].t.eachLeaf(pieces[i].offset, func(_ int, leaf []T) bool {
+			acc = fold(acc, leaf)
+			return true
+		})
+		results[i] = acc
+	})
+	acc := init
+	for _, r := range results {
+		acc = merge(acc, r)
+	}
+	return acc
+}
+
+// mapped_nodes remembers what every node was mapped to, so a node that a
+// trie holds in several places is mapped once and shared the same way in
+// the result, even when several workers come across it at the same time
+type mapped_nodes[T, U any] struct {
+	f     func(T) U
+	mu    sync.Mutex
+	nodes map[*Trie[T]]*mapped_node[U]
+}
+
+type mapped_node[U any] struct {
+	once sync.Once
+	t    *Trie[U]
+}
+
+func new_mapped_nodes[T, U any](f func(T) U) *mapped_nodes[T, U] {
+	return &mapped_nodes[T, U]{f: f, nodes: map[*Trie[T]]*mapped_node[U]{}}
+}
+
+func (mn *mapped_nodes[T, U])get(t *Trie[T]) *Trie[U] {
+	mn.mu.Lock()
+	n, ok := mn.nodes[t]
+	if !ok {
+		n = &mapped_node[U]{}
+		mn.nodes[t] = n
+	}
+	mn.mu.Unlock()
+	n.once.Do(func() {
+		n.t = mapTrie(t, mn)
+	})
+	return n.t
+}
+
+// mapTrie rebuilds t with f applied to every element. The new trie has the
+// same shape as t, so there is no appending or rebalancing to do.
+func mapTrie[T, U any](t *Trie[T], mn *mapped_nodes[T, U]) *Trie[U] {
+	n := NewTrie[U](t.height)
+	n.length = t.length
+	if t.height == 0 {
+		for i := 0; i < t.length; i++ {
+			n.content[i] = mn.f(t.content[i])
+		}
+		return n
+	}
+	for i := 0; i < t.length; i++ {
+		n.subtrie[i] = mn.get(t.subtrie[i])
+	}
+	*n.subsize = *t.subsize
+	return n
+}
+
+// ParallelMap returns a new persistent trie with f applied to every element
+// of t. The pieces are mapped by the workers, and the nodes above them are
+// rebuilt with the same shape as t. A subtrie that t shares in several places
+// is mapped once and shared the same way in the result.
+func ParallelMap[T, U any](t *Trie[T], workers int, f func(T) U) *Trie[U] {
+	workers = workers_or_default(workers)
+	pieces := split(t, workers*pieces_per_worker)
+	mn := new_mapped_nodes(f)
+	run(len(pieces), workers, func(i int) {
+		mn.get(pieces[i].t)
+	})
+	return mn.get(t)
+}
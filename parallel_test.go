@@ -0,0 +1,133 @@
+package web
+
+import (
+	"bytes"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func trieBytes(a *Trie[byte]) []byte {
+	out := make([]byte, 0, a.Size())
+	a.eachLeaf(0, func(_ int, leaf []byte) bool {
+		out = append(out, leaf...)
+		return true
+	})
+	return out
+}
+
+func TestParallelForEachLeaf(t *testing.T) {
+	ref := randSeq(10000)
+	a := TrieFromSlice[byte](ref)
+	for _, workers := range []int{0, 1, 3, 16} {
+		got := make([]byte, len(ref))
+		var mu sync.Mutex
+		seen := 0
+		ParallelForEachLeaf(a, workers, func(offset int, leaf []byte) {
+			copy(got[offset:], leaf)
+			mu.Lock()
+			seen += len(leaf)
+			mu.Unlock()
+		})
+		if seen != len(ref) {
+			t.Fatalf("workers %d: visited %d elements != %d", workers, seen, len(ref))
+		}
+		if !bytes.Equal(got, ref) {
+			t.Fatalf("workers %d: leaves do not line up with their offsets", workers)
+		}
+	}
+}
+
+func TestParallelFold(t *testing.T) {
+	ref := bytes.Repeat([]byte("some line\nand another\n\n"), 700)
+	a := TrieFromSlice[byte](ref)
+	nl := []byte("\n")
+	lines := ParallelFold(a, 8, 0,
+		func(n int, leaf []byte) int { return n + bytes.Count(leaf, nl) },
+		func(l, r int) int { return l + r })
+	if lines != bytes.Count(ref, nl) {
+		t.Fatalf("lines = %d != %d", lines, bytes.Count(ref, nl))
+	}
+
+	// order matters for concatenation, so this also checks the merge order
+	joined := ParallelFold(a, 8, []byte(nil),
+		func(acc []byte, leaf []byte) []byte { return append(acc, leaf...) },
+		func(l, r []byte) []byte { return append(l, r...) })
+	h1, h2 := fnv.New64a(), fnv.New64a()
+	h1.Write(joined)
+	h2.Write(ref)
+	if h1.Sum64() != h2.Sum64() {
+		t.Fatalf("folded bytes hash differently from the reference")
+	}
+}
+
+func TestParallelFoldEmpty(t *testing.T) {
+	a := NewTrie[byte](0)
+	n := ParallelFold(a, 4, 0,
+		func(n int, leaf []byte) int { return n + len(leaf) },
+		func(l, r int) int { return l + r })
+	if n != 0 {
+		t.Fatalf("fold over an empty trie = %d", n)
+	}
+}
+
+func TestParallelMap(t *testing.T) {
+	ref := randSeq(5000)
+	a := TrieFromSlice[byte](ref)
+	up := ParallelMap(a, 4, func(c byte) byte {
+		return bytes.ToUpper([]byte{c})[0]
+	})
+	if up.Size() != a.Size() || up.height != a.height {
+		t.Fatalf("mapped size %d height %d, want %d %d",
+			up.Size(), up.height, a.Size(), a.height)
+	}
+	if !bytes.Equal(trieBytes(up), bytes.ToUpper(ref)) {
+		t.Fatalf("mapped contents differ")
+	}
+	if !bytes.Equal(trieBytes(a), ref) {
+		t.Fatalf("ParallelMap changed its input")
+	}
+
+	lens := ParallelMap(a, 4, func(c byte) int { return int(c) })
+	r, err := lens.ReadSlice(1234)
+	if err != nil || r[0] != int(ref[1234]) {
+		t.Fatalf("lens.ReadSlice(1234) = %v, %v", r, err)
+	}
+}
+
+// a trie holding the same subtries twice gets them mapped once
+func TestParallelMapShared(t *testing.T) {
+	half := TrieFromSlice[byte](randSeq(m*m*3))
+	a := half.Concat(half)
+	if Stats(a).Shared == 0 {
+		t.Fatalf("the halves share no nodes")
+	}
+	var calls atomic.Int64
+	up := ParallelMap(a, 4, func(c byte) byte {
+		calls.Add(1)
+		return c+1
+	})
+	if int(calls.Load()) != Stats(a).Elements {
+		t.Fatalf("f called %d times for %d unique elements", calls.Load(), Stats(a).Elements)
+	}
+	if s := Stats(up); s.Nodes != Stats(a).Nodes {
+		t.Fatalf("the mapped trie has %d nodes, not %d", s.Nodes, Stats(a).Nodes)
+	}
+	got, want := trieBytes(up), trieBytes(a)
+	for i := range want {
+		if got[i] != want[i]+1 {
+			t.Fatalf("up[%d] = %d, not %d", i, got[i], want[i]+1)
+		}
+	}
+}
+
+func BenchmarkParallelFoldLines(b *testing.B) {
+	a := TrieFromSlice[byte](bytes.Repeat([]byte("a line of text\n"), 100000))
+	nl := []byte("\n")
+	for i := 0; i < b.N; i++ {
+		ParallelFold(a, 0, 0,
+			func(n int, leaf []byte) int { return n + bytes.Count(leaf, nl) },
+			func(l, r int) int { return l + r })
+	}
+}
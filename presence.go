@@ -0,0 +1,228 @@
+/* Presence is where everyone else is in a document: their cursors and what
+   they have selected. Presences follows the revisions of a Session, and
+   every op it accepts moves the selections so they stay on the text they
+   were on. Selections come in with the revision they were made on, and are
+   moved through the ops since then first, the way the session moves ops.
+   Users that have not moved for a while are dropped. Subscribers get the
+   whole picture, but no more often than once an interval, however often
+   people type, so a change can take up to an interval to arrive and the
+   changes in between are sent as one.
+ */
+package web
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Selection runs from Anchor, where it started, to Head, where the cursor
+// is. A cursor is a Selection with Anchor == Head.
+type Selection struct {
+	Anchor int `json:"anchor"`
+	Head   int `json:"head"`
+}
+
+type Presence struct {
+	User       string      `json:"user"`
+	Selections []Selection `json:"selections"`
+}
+
+type present_user struct {
+	sels []Selection
+	seen time.Time
+}
+
+type Presences struct {
+	session  *Session
+	cancel   func()
+	idle     time.Duration
+	interval time.Duration
+	// send keeps broadcasts in order. mu guards the rest
+	send     sync.Mutex
+	mu       sync.Mutex
+	rev      int // of the session, which the selections are on
+	users    map[string]*present_user
+	last     time.Time // of the last broadcast
+	flush    *time.Timer
+	expire   *time.Timer
+	closed   bool
+	subs     map[int]func([]Presence)
+	next     int
+}
+
+// NewPresences follows the revisions of s, drops users after idle without
+// an update, and broadcasts at most once every interval.
+func NewPresences(s *Session, idle, interval time.Duration) *Presences {
+	p := &Presences{session: s, idle: idle, interval: interval, users: map[string]*present_user{}, subs: map[int]func([]Presence){}}
+	p.cancel = s.Subscribe(p.transform)
+	// revisions from before the subscription have nothing to move
+	_, rev := s.Snapshot()
+	p.mu.Lock()
+	p.rev = max(p.rev, rev)
+	p.mu.Unlock()
+	return p
+}
+
+// Set sets the selections of user, made on revision rev of the session, and
+// marks them as active. The selections are moved through the ops since rev.
+// It fails with ErrRevision if the session no longer has them.
+func (p *Presences)Set(user string, rev int, sels []Selection) error {
+	sels = append([]Selection(nil), sels...)
+	for {
+		revs, err := p.session.Since(rev)
+		if err != nil {
+			return err
+		}
+		for _, r := range revs {
+			transform_selections(r.Op, sels)
+			rev = r.Rev
+		}
+		p.mu.Lock()
+		// unless more ops came in meanwhile, which the next round moves
+		// the selections through
+		if p.rev <= rev {
+			break
+		}
+		p.mu.Unlock()
+	}
+	defer p.mu.Unlock()
+	p.users[user] = &present_user{sels, time.Now()}
+	if p.expire == nil && !p.closed {
+		p.expire = time.AfterFunc(p.idle, p.drop_idle)
+	}
+	p.changed()
+	return nil
+}
+
+func transform_selections(op Op, sels []Selection) {
+	for i, s := range sels {
+		sels[i] = Selection{op.TransformIndex(s.Anchor), op.TransformIndex(s.Head)}
+	}
+}
+
+// Remove drops user, who left.
+func (p *Presences)Remove(user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.users[user]; ok {
+		delete(p.users, user)
+		p.changed()
+	}
+}
+
+// transform moves the selections through the op of r, which the session
+// just accepted
+func (p *Presences)transform(r Revision) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rev = r.Rev
+	if r.Op.IsNoop() {
+		return
+	}
+	for _, u := range p.users {
+		transform_selections(r.Op, u.sels)
+	}
+	if len(p.users) > 0 {
+		p.changed()
+	}
+}
+
+// Get returns everyone, by user.
+func (p *Presences)Get() []Presence {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.get()
+}
+
+func (p *Presences)get() []Presence {
+	out := make([]Presence, 0, len(p.users))
+	for user, u := range p.users {
+		out = append(out, Presence{user, append([]Selection(nil), u.sels...)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].User < out[j].User })
+	return out
+}
+
+// changed schedules a broadcast, unless one is already
+func (p *Presences)changed() {
+	if p.flush != nil || p.closed {
+		return
+	}
+	wait := max(0, p.interval-time.Since(p.last))
+	p.flush = time.AfterFunc(wait, p.broadcast)
+}
+
+func (p *Presences)broadcast() {
+	p.send.Lock()
+	defer p.send.Unlock()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.flush = nil
+	p.last = time.Now()
+	all := p.get()
+	subs := make([]func([]Presence), 0, len(p.subs))
+	for _, f := range p.subs {
+		subs = append(subs, f)
+	}
+	p.mu.Unlock()
+	for _, f := range subs {
+		f(all)
+	}
+}
+
+// drop_idle drops the users that have been idle too long, and runs again
+// when the next one will have been
+func (p *Presences)drop_idle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire = nil
+	if p.closed {
+		return
+	}
+	var next time.Time
+	for user, u := range p.users {
+		switch until := u.seen.Add(p.idle); {
+		case !time.Now().Before(until):
+			delete(p.users, user)
+			p.changed()
+		case next.IsZero() || until.Before(next):
+			next = until
+		}
+	}
+	if !next.IsZero() {
+		p.expire = time.AfterFunc(time.Until(next), p.drop_idle)
+	}
+}
+
+// Subscribe calls f with everyone after every change, at most once an
+// interval, until cancel is called.
+func (p *Presences)Subscribe(f func([]Presence)) (cancel func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.next
+	p.next++
+	p.subs[id] = f
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.subs, id)
+	}
+}
+
+// Close stops following the session, and stops the timers. Nothing is
+// broadcast after it.
+func (p *Presences)Close() {
+	p.cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, t := range []*time.Timer{p.flush, p.expire} {
+		if t != nil {
+			t.Stop()
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransformIndex(t *testing.T) {
+	var o Op
+	// "hello world" to "hi world!"
+	o.Retain(1).Insert([]byte("i")).Delete(4).Retain(6).Insert([]byte("!"))
+	for _, c := range []struct{ in, out int }{
+		{0, 0}, {1, 2}, {3, 2}, {5, 2}, {6, 3}, {11, 9},
+	} {
+		if got := o.TransformIndex(c.in); got != c.out {
+			t.Errorf("TransformIndex(%d) = %d, want %d", c.in, got, c.out)
+		}
+	}
+}
+
+func TestPresenceTransform(t *testing.T) {
+	s := NewSession(TrieFromSlice[byte]([]byte("hello world")))
+	p := NewPresences(s, time.Minute, time.Millisecond)
+	defer p.Close()
+
+	p.Set("a", 0, []Selection{{6, 11}})
+	p.Set("b", 0, []Selection{{0, 0}, {5, 5}})
+	s.Receive("c", 0, ReplaceOp(11, 0, 5, []byte("goodbye,")))
+	// made on revision 0, and sent after the op above was taken
+	if err := p.Set("d", 0, []Selection{{11, 11}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Set("e", 5, nil); err != ErrRevision {
+		t.Fatalf("Set on a revision that never was: %v", err)
+	}
+	want := []Presence{
+		{"a", []Selection{{9, 14}}},
+		{"b", []Selection{{8, 8}, {8, 8}}},
+		{"d", []Selection{{14, 14}}},
+	}
+	got := p.Get()
+	if len(got) != len(want) {
+		t.Fatalf("Get() = %v", got)
+	}
+	for i := range want {
+		if got[i].User != want[i].User || len(got[i].Selections) != len(want[i].Selections) {
+			t.Fatalf("Get() = %v, want %v", got, want)
+		}
+		for j := range want[i].Selections {
+			if got[i].Selections[j] != want[i].Selections[j] {
+				t.Fatalf("Get() = %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+// selections set while ops keep coming in end up moved through all of them
+func TestPresenceConcurrent(t *testing.T) {
+	s := NewSession(TrieFromSlice[byte]([]byte("x")))
+	p := NewPresences(s, time.Minute, time.Millisecond)
+	defer p.Close()
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 200; i++ {
+			text, rev := s.Snapshot()
+			s.Receive("b", rev, ReplaceOp(text.Size(), 0, 0, []byte("y")))
+		}
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		// a cursor at the end, which every insert at the start moves
+		text, rev := s.Snapshot()
+		if err := p.Set("a", rev, []Selection{{text.Size(), text.Size()}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := p.Get()[0].Selections[0].Head; got != 201 {
+		t.Fatalf("the cursor is at %d, not at the end", got)
+	}
+}
+
+func TestPresenceBroadcast(t *testing.T) {
+	const interval = 50*time.Millisecond
+	p := NewPresences(NewSession(TrieFromSlice[byte](nil)), time.Hour, interval)
+	defer p.Close()
+	seen := make(chan []Presence, 100)
+	p.Subscribe(func(all []Presence) { seen <- all })
+
+	// a burst of updates is sent as one
+	for i := 0; i < 20; i++ {
+		p.Set("a", 0, []Selection{{i, i}})
+	}
+	all := <-seen
+	if len(all) != 1 || all[0].Selections[0].Head != 19 {
+		t.Fatalf("broadcast %v", all)
+	}
+	p.Set("b", 0, nil)
+	start := time.Now()
+	all = <-seen
+	if len(all) != 2 {
+		t.Fatalf("broadcast %v", all)
+	}
+	if waited := time.Since(start); waited < interval/2 {
+		t.Fatalf("broadcast again after %v", waited)
+	}
+	select {
+	case all := <-seen:
+		t.Fatalf("broadcast without a change: %v", all)
+	case <-time.After(2*interval):
+	}
+}
+
+func TestPresenceIdle(t *testing.T) {
+	p := NewPresences(NewSession(TrieFromSlice[byte](nil)), 30*time.Millisecond, time.Millisecond)
+	defer p.Close()
+	seen := make(chan []Presence, 100)
+	p.Subscribe(func(all []Presence) { seen <- all })
+	p.Set("a", 0, []Selection{{1, 1}})
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case all := <-seen:
+			if len(all) == 0 {
+				if got := p.Get(); len(got) != 0 {
+					t.Fatalf("Get() = %v after a expired", got)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("a was never dropped")
+		}
+	}
+}
@@ -0,0 +1,257 @@
+/* A project is a directory of documents that are parsed and indexed
+   together, so that a click on a name goes to its definition in whichever
+   file it is in, and a definition can list where it is used.
+   Edits go through the project, which hands the new version to the index
+   and to the diagnostics straight away, so answers always match the latest
+   text.
+ */
+package web
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+var ErrNoDocument = errors.New("web: no such document")
+
+type project_file struct {
+	mu   sync.Mutex // edits and their index updates go in order
+	doc  *Document
+	lang *Language
+}
+
+type Project struct {
+	reg         *Registry
+	index       *Index
+	diagnostics *Diagnostics
+	mu          sync.RWMutex
+	files       map[string]*project_file
+}
+
+func NewProject(reg *Registry) *Project {
+	return &Project{reg: reg, index: NewIndex(reg), diagnostics: NewDiagnostics(reg),
+		files: map[string]*project_file{}}
+}
+
+// OpenProject adds every file under root with a language to a new project,
+// by its slash separated path from root. Dot directories like .git are
+// skipped.
+func OpenProject(reg *Registry, root string) (*Project, error) {
+	p := NewProject(reg)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d, l := reg.Open(rel, text); l != nil {
+			p.put(rel, d, l)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Project)Index() *Index {
+	return p.index
+}
+
+func (p *Project)Diagnostics() *Diagnostics {
+	return p.diagnostics
+}
+
+// Add parses text as the document at path, in the language detected for it,
+// and indexes it. A document already at path is replaced.
+func (p *Project)Add(path string, text []byte) (*Document, *Language) {
+	d, l := p.reg.Open(path, text)
+	p.put(path, d, l)
+	return d, l
+}
+
+func (p *Project)put(path string, d *Document, l *Language) {
+	f := &project_file{doc: d, lang: l}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p.mu.Lock()
+	p.files[path] = f
+	p.mu.Unlock()
+	p.index.Update(path, l, d.Current())
+	p.diagnostics.Update(path, l, d.Current())
+}
+
+func (p *Project)file(path string) *project_file {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.files[path]
+}
+
+// Document returns the document at path, or nil. Edit it through the
+// project, or the index falls behind.
+func (p *Project)Document(path string) *Document {
+	if f := p.file(path); f != nil {
+		return f.doc
+	}
+	return nil
+}
+
+func (p *Project)Remove(path string) {
+	p.mu.Lock()
+	delete(p.files, path)
+	p.mu.Unlock()
+	p.index.Remove(path)
+	p.diagnostics.Remove(path)
+}
+
+// Paths lists the documents of the project.
+func (p *Project)Paths() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]string, 0, len(p.files))
+	for path := range p.files {
+		out = append(out, path)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Texts returns the text of every version of every document, such as for
+// Stats.
+func (p *Project)Texts() []*Trie[byte] {
+	var out []*Trie[byte]
+	for _, path := range p.Paths() {
+		if d := p.Document(path); d != nil {
+			out = append(out, d.Texts()...)
+		}
+	}
+	return out
+}
+
+// Replace replaces n bytes at off in the document at path with text, and
+// indexes the new version.
+func (p *Project)Replace(path string, off, n int, text []byte) (*Version, error) {
+	f := p.file(path)
+	if f == nil {
+		return nil, ErrNoDocument
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, err := f.doc.Replace(off, n, text)
+	if err != nil {
+		return nil, err
+	}
+	// unless the document was replaced or removed meanwhile
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.files[path] == f {
+		p.index.Update(path, f.lang, v)
+		p.diagnostics.Update(path, f.lang, v)
+	}
+	return v, nil
+}
+
+// Apply makes the edits of a StructuralEdit worked out on revision rev of
+// the document at path, as Document.Apply does, and indexes the new version.
+func (p *Project)Apply(path string, rev int, edits []TextEdit) (*Version, error) {
+	f := p.file(path)
+	if f == nil {
+		return nil, ErrNoDocument
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, err := f.doc.Apply(rev, edits)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.files[path] == f {
+		p.index.Update(path, f.lang, v)
+		p.diagnostics.Update(path, f.lang, v)
+	}
+	return v, nil
+}
+
+// SetLanguage parses the document at path again as lang, when the language
+// it was detected as is wrong, and indexes it.
+func (p *Project)SetLanguage(path string, lang *Language) (*Version, error) {
+	f := p.file(path)
+	if f == nil {
+		return nil, ErrNoDocument
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var grammar *sitter.Language
+	if lang != nil {
+		grammar = lang.Grammar
+	}
+	v := f.doc.SetLanguage(grammar)
+	f.lang = lang
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.files[path] == f {
+		p.index.Update(path, f.lang, v)
+		p.diagnostics.Update(path, f.lang, v)
+	}
+	return v, nil
+}
+
+// Language returns the language of the document at path, or nil.
+func (p *Project)Language(path string) *Language {
+	f := p.file(path)
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lang
+}
+
+// Definition returns the definitions of the name at off in the document at
+// path, the ones in that document first.
+func (p *Project)Definition(path string, off int) []Symbol {
+	name, ok := p.index.NameAt(path, off)
+	if !ok {
+		return nil
+	}
+	defs := p.index.Definitions(name)
+	sort.SliceStable(defs, func(i, j int) bool {
+		return defs[i].Path == path && defs[j].Path != path
+	})
+	return defs
+}
+
+// References returns every use of the name at off in the document at path,
+// in all documents.
+func (p *Project)References(path string, off int) []Reference {
+	name, ok := p.index.NameAt(path, off)
+	if !ok {
+		return nil
+	}
+	return p.index.References(name)
+}
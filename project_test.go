@@ -0,0 +1,169 @@
+package web
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var project_files = map[string]string{
+	"a.go": "package p\n\ntype Config struct{ Name string }\n\nfunc Helper(c Config) string {\n\treturn c.Name\n}\n",
+	"cmd/b.go": "package p\n\nfunc main() {\n\tc := Config{}\n\tprintln(Helper(c))\n}\n",
+	"docs/page.md": "# Helper\n\n```go\nfunc use() { Helper(Config{}) }\n```\n",
+	".git/hooks.go": "package hooks\n\nfunc Helper() {}\n",
+	"notes.txt": "Helper\n",
+}
+
+func testProject(t *testing.T) *Project {
+	root := t.TempDir()
+	for path, text := range project_files {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := OpenProject(DefaultRegistry(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func locations[S Symbol | Reference](text func(path string) string, xs []S) []string {
+	var out []string
+	for _, x := range xs {
+		var path string
+		var r [2]uint32
+		switch x := any(x).(type) {
+		case Symbol:
+			path, r = x.Path, [2]uint32{x.NameRange.StartByte, x.NameRange.EndByte}
+		case Reference:
+			path, r = x.Path, [2]uint32{x.Range.StartByte, x.Range.EndByte}
+		}
+		out = append(out, path+":"+text(path)[:r[0]]+"|"+text(path)[r[0]:r[1]])
+	}
+	return out
+}
+
+func TestProject(t *testing.T) {
+	p := testProject(t)
+	if got, want := p.Paths(), []string{"a.go", "cmd/b.go", "docs/page.md"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Paths = %v, want %v", got, want)
+	}
+	text := func(path string) string {
+		return string(Bytes(TrieBufferOf(p.Document(path).Current().Text)))
+	}
+	short := func(xs []string) []string {
+		// just the line the location is on
+		for i, x := range xs {
+			path, rest, _ := strings.Cut(x, ":")
+			xs[i] = path + ":" + rest[strings.LastIndexByte(rest, '\n')+1:]
+		}
+		return xs
+	}
+
+	b := project_files["cmd/b.go"]
+	defs := p.Definition("cmd/b.go", strings.Index(b, "Helper")+2)
+	// the heading on the page counts too, names are all there is to go by
+	if got := short(locations(text, defs)); !reflect.DeepEqual(got, []string{"a.go:func |Helper", "docs/page.md:# |Helper"}) {
+		t.Fatalf("Definition(Helper) = %v", got)
+	}
+	// and from the page, the page comes first
+	page := project_files["docs/page.md"]
+	defs = p.Definition("docs/page.md", strings.Index(page, "Helper(Config"))
+	if len(defs) != 2 || defs[0].Path != "docs/page.md" || defs[1].Path != "a.go" {
+		t.Fatalf("Definition from the page = %v", defs)
+	}
+
+	refs := p.References("a.go", strings.Index(project_files["a.go"], "Helper"))
+	want := []string{"cmd/b.go:\tprintln(|Helper", "docs/page.md:func use() { |Helper"}
+	if got := short(locations(text, refs)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("References(Helper) = %v, want %v", got, want)
+	}
+
+	// edits show up straight away
+	a := project_files["a.go"]
+	if _, err := p.Replace("a.go", strings.Index(a, "Helper"), len("Helper"), []byte("Assist")); err != nil {
+		t.Fatal(err)
+	}
+	if defs := p.Definition("cmd/b.go", strings.Index(b, "Helper")); len(defs) != 1 || defs[0].Kind != "heading" {
+		t.Fatalf("after the rename Helper is defined at %v", defs)
+	}
+	if refs := p.References("a.go", strings.Index(a, "Helper")); len(refs) != 0 {
+		t.Fatalf("Assist is used at %v", refs)
+	}
+	if _, err := p.Replace("nope.go", 0, 0, nil); err != ErrNoDocument {
+		t.Fatalf("Replace on a missing document: %v", err)
+	}
+}
+
+func TestProjectEdits(t *testing.T) {
+	p := testProject(t)
+	rng := rand.New(rand.NewSource(1))
+	paths := p.Paths()
+	for i := 0; i < 200; i++ {
+		path := paths[rng.Intn(len(paths))]
+		size := p.Document(path).Current().Text.Size()
+		off := rng.Intn(size + 1)
+		var err error
+		if rng.Intn(2) == 0 && off < size {
+			_, err = p.Replace(path, off, min(rng.Intn(6), size-off), nil)
+		} else {
+			_, err = p.Replace(path, off, 0, []byte([]string{"Helper", "Config", " ", "x", "}", "(", "\n"}[rng.Intn(7)]))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the documents keep every version, 3 first ones and 200 edits
+	if texts := p.Texts(); len(texts) != len(paths)+200 {
+		t.Fatalf("Texts gave %d versions", len(texts))
+	}
+
+	fresh := NewIndex(DefaultRegistry())
+	for _, path := range paths {
+		f := p.file(path)
+		v := f.doc.Current()
+		fresh.Update(path, f.lang, &Version{Text: v.Text, Tree: v.Tree})
+	}
+	for _, name := range []string{"Helper", "Config", "Name", "c", "main", "x"} {
+		if got, want := p.Index().Definitions(name), fresh.Definitions(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("Definitions(%s) = %v, want %v", name, got, want)
+		}
+		if got, want := p.Index().References(name), fresh.References(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("References(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// a structural edit made through the project is indexed like any other
+func TestProjectApply(t *testing.T) {
+	p := testProject(t)
+	a := project_files["a.go"]
+	v := p.Document("a.go").Current()
+	at := strings.Index(a, "type Config")
+	e, ok := SwapSibling(v, at, at+1, true)
+	if !ok {
+		t.Fatal("no swap")
+	}
+	if _, err := p.Apply("a.go", v.Rev, e.Edits); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Apply("a.go", v.Rev, e.Edits); err != ErrStaleEdits {
+		t.Fatalf("Apply of the same edits again: %v", err)
+	}
+	text := string(Bytes(TrieBufferOf(p.Document("a.go").Current().Text)))
+	if strings.Index(text, "func Helper") > strings.Index(text, "type Config") {
+		t.Fatalf("not swapped:\n%s", text)
+	}
+	defs := p.Index().Definitions("Config")
+	if len(defs) != 1 || int(defs[0].NameRange.StartByte) != strings.Index(text, "Config struct") {
+		t.Fatalf("Definitions(Config) after the swap = %v", defs)
+	}
+}
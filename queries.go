@@ -0,0 +1,227 @@
+package web
+
+// the queries the builtin languages are registered with
+
+// tag queries capture definitions as @definition.<kind> with their @name,
+// and every identifier that can refer to one as @reference
+
+const go_tags = `
+(function_declaration name: (identifier) @name) @definition.function
+(method_declaration name: (field_identifier) @name) @definition.method
+(type_spec name: (type_identifier) @name) @definition.type
+(const_spec name: (identifier) @name) @definition.constant
+[(identifier) (type_identifier) (field_identifier) (package_identifier)] @reference
+`
+
+const python_tags = `
+(class_definition name: (identifier) @name) @definition.class
+(function_definition name: (identifier) @name) @definition.function
+(class_definition body: (block
+	(function_definition name: (identifier) @name) @definition.method))
+(identifier) @reference
+`
+
+const rust_tags = `
+(function_item name: (identifier) @name) @definition.function
+(struct_item name: (type_identifier) @name) @definition.type
+(enum_item name: (type_identifier) @name) @definition.type
+(trait_item name: (type_identifier) @name) @definition.interface
+(const_item name: (identifier) @name) @definition.constant
+(impl_item body: (declaration_list
+	(function_item name: (identifier) @name) @definition.method))
+[(identifier) (type_identifier) (field_identifier)] @reference
+`
+
+const javascript_tags = `
+(function_declaration name: (identifier) @name) @definition.function
+(generator_function_declaration name: (identifier) @name) @definition.function
+(class_declaration name: (identifier) @name) @definition.class
+(method_definition name: (property_identifier) @name) @definition.method
+(variable_declarator
+	name: (identifier) @name
+	value: [(arrow_function) (function_expression)]) @definition.function
+[(identifier) (property_identifier)] @reference
+`
+
+const typescript_tags = `
+(function_declaration name: (identifier) @name) @definition.function
+(class_declaration name: (type_identifier) @name) @definition.class
+(method_definition name: (property_identifier) @name) @definition.method
+(interface_declaration name: (type_identifier) @name) @definition.interface
+(type_alias_declaration name: (type_identifier) @name) @definition.type
+(enum_declaration name: (identifier) @name) @definition.type
+(variable_declarator
+	name: (identifier) @name
+	value: [(arrow_function) (function_expression)]) @definition.function
+[(identifier) (property_identifier) (type_identifier)] @reference
+`
+
+const c_tags = `
+(function_definition
+	declarator: (function_declarator declarator: (identifier) @name)) @definition.function
+(struct_specifier name: (type_identifier) @name body: (_)) @definition.type
+(type_definition declarator: (type_identifier) @name) @definition.type
+[(identifier) (type_identifier) (field_identifier)] @reference
+`
+
+const markdown_injections = `
+(fenced_code_block
+	(info_string (language) @injection.language)
+	(code_fence_content) @injection.content)
+`
+
+const html_injections = `
+((script_element (raw_text) @injection.content)
+	(#set! injection.language "javascript"))
+((style_element (raw_text) @injection.content)
+	(#set! injection.language "css"))
+`
+
+// headings are what wiki links point at. the section under a heading
+// holds the sections of its subheadings, which gives the outline its shape
+const markdown_tags = `
+(section (atx_heading heading_content: (_) @name)) @definition.heading
+`
+
+// highlight queries. where captures overlap the outer one wins, so these
+// stick to small nodes
+
+const go_highlights = `
+(comment) @comment
+[(interpreted_string_literal) (raw_string_literal) (rune_literal)] @string
+(escape_sequence) @string.escape
+[(int_literal) (float_literal) (imaginary_literal)] @number
+[(true) (false) (nil) (iota)] @constant.builtin
+(type_identifier) @type
+(package_identifier) @module
+(function_declaration name: (identifier) @function)
+(method_declaration name: (field_identifier) @function.method)
+(call_expression function: (identifier) @function.call)
+(call_expression function: (selector_expression field: (field_identifier) @function.call))
+["break" "case" "chan" "const" "continue" "default" "defer" "else"
+ "fallthrough" "for" "func" "go" "goto" "if" "import" "interface" "map"
+ "package" "range" "return" "select" "struct" "switch" "type" "var"] @keyword
+`
+
+const python_highlights = `
+(comment) @comment
+(string) @string
+[(integer) (float)] @number
+[(true) (false) (none)] @constant.builtin
+(decorator) @attribute
+(class_definition name: (identifier) @type)
+(function_definition name: (identifier) @function)
+(call function: (identifier) @function.call)
+(call function: (attribute attribute: (identifier) @function.call))
+["and" "as" "assert" "break" "class" "continue" "def" "del" "elif" "else"
+ "except" "finally" "for" "from" "global" "if" "import" "in" "is" "lambda"
+ "nonlocal" "not" "or" "pass" "raise" "return" "try" "while" "with"
+ "yield"] @keyword
+`
+
+const rust_highlights = `
+[(line_comment) (block_comment)] @comment
+[(string_literal) (raw_string_literal) (char_literal)] @string
+[(integer_literal) (float_literal)] @number
+(boolean_literal) @constant.builtin
+(primitive_type) @type.builtin
+(type_identifier) @type
+(function_item name: (identifier) @function)
+(call_expression function: (identifier) @function.call)
+(macro_invocation macro: (identifier) @function.macro)
+(mutable_specifier) @keyword
+["as" "const" "else" "enum" "fn" "for" "if" "impl" "in" "let" "loop"
+ "match" "mod" "move" "pub" "ref" "return" "static" "struct" "trait"
+ "type" "unsafe" "use" "where" "while"] @keyword
+`
+
+const javascript_highlights = `
+(comment) @comment
+[(string) (template_string)] @string
+(regex) @string.special
+(number) @number
+[(true) (false) (null) (undefined)] @constant.builtin
+(class_declaration name: (_) @type)
+(function_declaration name: (identifier) @function)
+(method_definition name: (property_identifier) @function.method)
+(call_expression function: (identifier) @function.call)
+(call_expression function: (member_expression property: (property_identifier) @function.call))
+["async" "await" "break" "case" "catch" "class" "const" "continue"
+ "default" "delete" "do" "else" "export" "extends" "finally" "for"
+ "function" "if" "import" "in" "instanceof" "let" "new" "of" "return"
+ "switch" "throw" "try" "typeof" "var" "while" "yield"] @keyword
+`
+
+const typescript_highlights = javascript_highlights + `
+(type_identifier) @type
+(predefined_type) @type.builtin
+["abstract" "enum" "implements" "interface" "private" "protected" "public"
+ "readonly" "type"] @keyword
+`
+
+const c_highlights = `
+(comment) @comment
+[(string_literal) (char_literal) (system_lib_string)] @string
+(number_literal) @number
+[(true) (false) (null)] @constant.builtin
+(primitive_type) @type.builtin
+(type_identifier) @type
+(function_declarator declarator: (identifier) @function)
+(call_expression function: (identifier) @function.call)
+["#define" "#include" "#if" "#ifdef" "#ifndef" "#else" "#endif"] @keyword.directive
+["break" "case" "const" "continue" "default" "do" "else" "enum" "extern"
+ "for" "goto" "if" "return" "sizeof" "static" "struct" "switch" "typedef"
+ "union" "while"] @keyword
+`
+
+// the text of the blocks is in the inline grammar, which we do not parse,
+// and code blocks get the highlights of their injected language
+const markdown_highlights = `
+[(atx_heading) (setext_heading)] @markup.heading
+[(fenced_code_block_delimiter) (thematic_break) (block_quote_marker)] @punctuation
+(info_string) @label
+(indented_code_block) @markup.raw
+[(list_marker_minus) (list_marker_plus) (list_marker_star)
+ (list_marker_dot) (list_marker_parenthesis)] @punctuation.list
+(link_reference_definition) @markup.link
+`
+
+// fold queries capture @fold, or @fold.<kind> for the kinds editors treat
+// differently, @fold.comment and @fold.imports
+
+const go_folds = `
+[(function_declaration) (method_declaration) (type_declaration)
+ (const_declaration) (var_declaration) (block) (composite_literal)
+ (func_literal)] @fold
+(import_declaration) @fold.imports
+(comment) @fold.comment
+`
+
+const python_folds = `
+[(function_definition) (class_definition) (if_statement) (for_statement)
+ (while_statement) (with_statement) (try_statement) (dictionary) (list)] @fold
+(string) @fold.comment
+`
+
+const rust_folds = `
+[(function_item) (impl_item) (struct_item) (enum_item) (trait_item)
+ (mod_item) (block) (match_block)] @fold
+(use_declaration) @fold.imports
+(block_comment) @fold.comment
+`
+
+const javascript_folds = `
+[(statement_block) (class_body) (object) (array) (template_string)
+ (switch_body)] @fold
+(comment) @fold.comment
+`
+
+const c_folds = `
+[(compound_statement) (field_declaration_list) (enumerator_list)
+ (initializer_list)] @fold
+(comment) @fold.comment
+`
+
+const markdown_folds = `
+[(section) (fenced_code_block) (list) (block_quote) (pipe_table)] @fold
+`
@@ -0,0 +1,100 @@
+/* A SharedBuffer lets many goroutines read a page while one goroutine at a
+   time edits it.
+   Since the trie is persistent, a reader never needs a lock: it loads the
+   current root and keeps using it, no matter what the writers do after that.
+   Writers are serialised with a mutex. Each write gets a fresh transient id
+   so that the edits inside one write reuse the nodes they allocate, and the
+   resulting root is published with a single atomic store.
+ */
+package web
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// a published version of the buffer. root and rev are always read together
+type version struct {
+	root *Trie[byte]
+	rev  int
+}
+
+type SharedBuffer struct {
+	mu  sync.Mutex // serialises writers, readers never touch it
+	cur atomic.Pointer[version]
+}
+
+func NewSharedBuffer(t *Trie[byte]) *SharedBuffer {
+	return NewSharedBufferAt(t, 0)
+}
+
+// NewSharedBufferAt starts the buffer at revision rev, for a page that was
+// loaded from storage.
+func NewSharedBufferAt(t *Trie[byte], rev int) *SharedBuffer {
+	if t == nil {
+		t = NewTrie[byte](0)
+	}
+	s := &SharedBuffer{}
+	s.cur.Store(&version{root: t, rev: rev})
+	return s
+}
+
+// Snapshot returns the current root and its revision. The root must only be
+// edited through persistent operations (id 0) or after calling Trans on it.
+func (s *SharedBuffer)Snapshot() (*Trie[byte], int) {
+	v := s.cur.Load()
+	return v.root, v.rev
+}
+
+func (s *SharedBuffer)Size() int {
+	return s.cur.Load().root.Size()
+}
+
+func (s *SharedBuffer)Revision() int {
+	return s.cur.Load().rev
+}
+
+// Update runs f on a transient copy of the current root and publishes what f
+// returns. f must use id for every edit it makes, and must not keep the trie
+// around after it returns.
+func (s *SharedBuffer)Update(f func(id int, t *Trie[byte]) *Trie[byte]) (*Trie[byte], int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.cur.Load()
+	n := old.root.Trans()
+	id := n.id
+	n = f(id, n)
+	if n == nil {
+		n = NewTrie[byte](0)
+	}
+	// only the nodes made during this write carry id, so the root is ours to
+	// seal. anything else f returned is already shared and left alone.
+	if n.id == id {
+		n.id = 0
+	}
+	v := &version{root: n, rev: old.rev+1}
+	s.cur.Store(v)
+	return v.root, v.rev
+}
+
+// Append publishes vs appended to the end of the buffer.
+func (s *SharedBuffer)Append(vs []byte) (*Trie[byte], int) {
+	return s.Update(func(id int, t *Trie[byte]) *Trie[byte] {
+		return t.AppendSliceTrans(vs)
+	})
+}
+
+// Replace publishes t as the new root, as long as nobody has published since
+// rev. It reports whether t was published.
+func (s *SharedBuffer)Replace(rev int, t *Trie[byte]) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.cur.Load()
+	if old.rev != rev {
+		return false
+	}
+	s.cur.Store(&version{root: t, rev: old.rev+1})
+	return true
+}
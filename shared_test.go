@@ -0,0 +1,154 @@
+package web
+
+import (
+	"sync"
+	"testing"
+)
+
+/* These tests are meant to be run with -race. The writers append bytes whose
+   value is their own index, so any snapshot a reader sees has to be
+   consistent with that pattern no matter when it was taken.
+ */
+
+func patternAt(i int) byte {
+	return byte(i % 251)
+}
+
+func checkPattern(t *testing.T, a *Trie[byte]) {
+	for i := 0; i < a.Size(); i++ {
+		r, err := a.ReadSlice(i)
+		if err != nil {
+			t.Errorf("ReadSlice(%d) of size %d returned err %v", i, a.Size(), err)
+			return
+		}
+		if r[0] != patternAt(i) {
+			t.Errorf("ReadSlice(%d) = %d != %d", i, r[0], patternAt(i))
+			return
+		}
+	}
+}
+
+func TestSharedBufferAppend(t *testing.T) {
+	s := NewSharedBuffer(nil)
+	for i := 0; i < 2000; i++ {
+		s.Append([]byte{patternAt(i)})
+	}
+	a, rev := s.Snapshot()
+	if rev != 2000 {
+		t.Fatalf("rev = %d != 2000", rev)
+	}
+	if a.Size() != 2000 {
+		t.Fatalf("a.Size() = %d != 2000", a.Size())
+	}
+	checkPattern(t, a)
+}
+
+func TestSharedBufferSnapshotPersists(t *testing.T) {
+	s := NewSharedBuffer(TrieFromSlice[byte]([]byte{0, 1, 2}))
+	a, _ := s.Snapshot()
+	s.Append([]byte{3, 4, 5})
+	if a.Size() != 3 {
+		t.Fatalf("old snapshot changed size to %d", a.Size())
+	}
+	b, _ := s.Snapshot()
+	if b.Size() != 6 {
+		t.Fatalf("b.Size() = %d != 6", b.Size())
+	}
+}
+
+func TestSharedBufferReplace(t *testing.T) {
+	s := NewSharedBuffer(nil)
+	_, rev := s.Snapshot()
+	s.Append([]byte("x"))
+	if s.Replace(rev, TrieFromSlice[byte]([]byte("stale"))) {
+		t.Fatalf("Replace with a stale revision succeeded")
+	}
+	if !s.Replace(rev+1, TrieFromSlice[byte]([]byte("fresh"))) {
+		t.Fatalf("Replace with the current revision failed")
+	}
+	if s.Size() != 5 || s.Revision() != 2 {
+		t.Fatalf("size %d rev %d after Replace", s.Size(), s.Revision())
+	}
+}
+
+func TestSharedBufferContention(t *testing.T) {
+	readers := 16
+	writes := 3000
+	s := NewSharedBuffer(nil)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last_size, last_rev := 0, 0
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				a, rev := s.Snapshot()
+				if rev < last_rev || a.Size() < last_size {
+					t.Errorf("went back from rev %d size %d to rev %d size %d",
+						last_rev, last_size, rev, a.Size())
+					return
+				}
+				last_size, last_rev = a.Size(), rev
+				if a.Size() > 0 {
+					r, err := a.ReadSlice(a.Size()-1)
+					if err != nil || r[0] != patternAt(a.Size()-1) {
+						t.Errorf("bad tail at size %d", a.Size())
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < writes; i++ {
+		s.Update(func(id int, a *Trie[byte]) *Trie[byte] {
+			return a.Append(id, patternAt(a.Size()))
+		})
+	}
+	close(done)
+	wg.Wait()
+
+	a, _ := s.Snapshot()
+	if a.Size() != writes {
+		t.Fatalf("a.Size() = %d != %d", a.Size(), writes)
+	}
+	checkPattern(t, a)
+}
+
+func TestSharedBufferConcurrentWriters(t *testing.T) {
+	writers := 8
+	writes := 500
+	s := NewSharedBuffer(nil)
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				s.Update(func(id int, a *Trie[byte]) *Trie[byte] {
+					return a.Append(id, patternAt(a.Size()))
+				})
+				a, _ := s.Snapshot()
+				if a.Size() == 0 {
+					t.Errorf("empty snapshot after a write")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	a, rev := s.Snapshot()
+	if a.Size() != writers*writes || rev != writers*writes {
+		t.Fatalf("size %d rev %d, want %d", a.Size(), rev, writers*writes)
+	}
+	checkPattern(t, a)
+}
@@ -0,0 +1,195 @@
+/* A snapshot file holds some versions of a document, written as the nodes
+   of their tries. Versions of one document share most of their nodes, and a
+   node that is in more than one of them is written once, so the history
+   kept for undo costs about as much as the edits in it. Reading the file
+   gives back tries that share the same way.
+	header  "web snap 1\n"
+	nodes   a count, then per node its height and length, and then for a
+	        leaf its bytes, for an inner node the indices of its children,
+	        which always come before it
+	roots   a count, then per version its revision and the index of its root
+	crc     uint32, little endian, Castagnoli, of everything after the header
+   All numbers are uvarints.
+ */
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+var ErrSnapshotFormat = errors.New("web: bad snapshot")
+
+const snapshot_header = "web snap 1\n"
+
+// Snapshot is the text of a document at a revision.
+type Snapshot struct {
+	Rev  int
+	Text *Trie[byte]
+}
+
+// Node returns the parts of the root node of t: its height, and its
+// elements if it is a leaf or its children if not. They must be left as they
+// are. With NewTrieNode it lets storage keep a trie a node at a time.
+func (t *Trie[T])Node() (height int, content []T, children []*Trie[T]) {
+	if t.height == 0 {
+		return 0, t.content[:t.length], nil
+	}
+	return t.height, nil, t.subtrie[:t.length]
+}
+
+// NewTrieNode makes the node Node took apart. It returns nil for parts that
+// do not make a node: more than 32 of them, or children that are not all one
+// level down.
+func NewTrieNode[T any](height int, content []T, children []*Trie[T]) *Trie[T] {
+	if height < 0 || len(content) > m || len(children) > m {
+		return nil
+	}
+	t := NewTrie[T](height)
+	if height == 0 {
+		t.length = copy(t.content[:], content)
+		return t
+	}
+	size := 0
+	for i, c := range children {
+		if c == nil || c.height != height-1 {
+			return nil
+		}
+		size += c.Size()
+		t.subtrie[i], t.subsize[i] = c, size
+	}
+	t.length = len(children)
+	return t
+}
+
+type snapshot_writer struct {
+	nodes map[*Trie[byte]]int
+	buf   []byte
+}
+
+// node writes t, after its children if they are not written yet, and
+// returns its index
+func (sw *snapshot_writer)node(t *Trie[byte]) int {
+	if i, ok := sw.nodes[t]; ok {
+		return i
+	}
+	var kids []int
+	if t.height > 0 {
+		for _, c := range t.subtrie[:t.length] {
+			kids = append(kids, sw.node(c))
+		}
+	}
+	sw.buf = binary.AppendUvarint(sw.buf, uint64(t.height))
+	sw.buf = binary.AppendUvarint(sw.buf, uint64(t.length))
+	if t.height == 0 {
+		sw.buf = append(sw.buf, t.content[:t.length]...)
+	}
+	for _, k := range kids {
+		sw.buf = binary.AppendUvarint(sw.buf, uint64(k))
+	}
+	i := len(sw.nodes)
+	sw.nodes[t] = i
+	return i
+}
+
+// WriteSnapshots writes snaps to w.
+func WriteSnapshots(w io.Writer, snaps []Snapshot) error {
+	sw := &snapshot_writer{nodes: map[*Trie[byte]]int{}}
+	roots := make([]int, len(snaps))
+	for i, s := range snaps {
+		roots[i] = sw.node(s.Text)
+	}
+	body := binary.AppendUvarint(nil, uint64(len(sw.nodes)))
+	body = append(body, sw.buf...)
+	body = binary.AppendUvarint(body, uint64(len(snaps)))
+	for i, s := range snaps {
+		body = binary.AppendUvarint(body, uint64(s.Rev))
+		body = binary.AppendUvarint(body, uint64(roots[i]))
+	}
+	body = binary.LittleEndian.AppendUint32(body, crc32.Checksum(body, log_crc))
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshot_header)
+	bw.Write(body)
+	return bw.Flush()
+}
+
+// ReadSnapshots reads the versions WriteSnapshots wrote to r.
+func ReadSnapshots(r io.Reader) ([]Snapshot, error) {
+	p, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(p, []byte(snapshot_header)) || len(p) < len(snapshot_header)+4 {
+		return nil, ErrSnapshotFormat
+	}
+	body, sum := p[len(snapshot_header):len(p)-4], p[len(p)-4:]
+	if crc32.Checksum(body, log_crc) != binary.LittleEndian.Uint32(sum) {
+		return nil, ErrSnapshotFormat
+	}
+
+	bad := false
+	uvarint := func() int {
+		v, n := binary.Uvarint(body)
+		if n <= 0 || v > math.MaxInt32 {
+			bad = true
+			return 0
+		}
+		body = body[n:]
+		return int(v)
+	}
+	// every node and every root takes two bytes at least
+	count := uvarint()
+	if count > len(body)/2 {
+		return nil, ErrSnapshotFormat
+	}
+	nodes := make([]*Trie[byte], count)
+	for i := range nodes {
+		h, length := uvarint(), uvarint()
+		if bad || length > m {
+			return nil, ErrSnapshotFormat
+		}
+		var t *Trie[byte]
+		if h == 0 {
+			if length > len(body) {
+				return nil, ErrSnapshotFormat
+			}
+			t = NewTrieNode(0, body[:length], nil)
+			body = body[length:]
+		} else {
+			kids := make([]*Trie[byte], length)
+			for j := range kids {
+				k := uvarint()
+				if bad || k >= i {
+					return nil, ErrSnapshotFormat
+				}
+				kids[j] = nodes[k]
+			}
+			if t = NewTrieNode(h, nil, kids); t == nil {
+				return nil, ErrSnapshotFormat
+			}
+		}
+		nodes[i] = t
+	}
+	count = uvarint()
+	if count > len(body)/2 {
+		return nil, ErrSnapshotFormat
+	}
+	snaps := make([]Snapshot, count)
+	for i := range snaps {
+		rev, root := uvarint(), uvarint()
+		if bad || root >= len(nodes) {
+			return nil, ErrSnapshotFormat
+		}
+		snaps[i] = Snapshot{rev, nodes[root]}
+	}
+	if bad || len(body) > 0 {
+		return nil, ErrSnapshotFormat
+	}
+	return snaps, nil
+}
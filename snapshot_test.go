@@ -0,0 +1,52 @@
+package web
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestSnapshotSharing(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var snaps []Snapshot
+	text := randomText(rng, 5000)
+	for rev := 0; rev < 10; rev++ {
+		snaps = append(snaps, Snapshot{rev, text})
+		// a Take copies just the path to where it cuts
+		text = text.Take(0, text.Size()-rng.Intn(100)-1)
+	}
+	snaps = append(snaps, Snapshot{10, TrieFromSlice[byte](nil)})
+
+	var one, all bytes.Buffer
+	WriteSnapshots(&one, snaps[:1])
+	if err := WriteSnapshots(&all, snaps); err != nil {
+		t.Fatal(err)
+	}
+	if all.Len() > one.Len()*3/2 {
+		t.Fatalf("10 versions take %d bytes, one %d", all.Len(), one.Len())
+	}
+
+	got, err := ReadSnapshots(bytes.NewReader(all.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(snaps) {
+		t.Fatalf("read %d snapshots, want %d", len(got), len(snaps))
+	}
+	for i := range snaps {
+		if got[i].Rev != snaps[i].Rev || trieString(got[i].Text) != trieString(snaps[i].Text) {
+			t.Fatalf("snapshot %d does not read back", i)
+		}
+	}
+	// and share them again once read, so they can be edited and written again
+	edited := got[3].Text.Insert(0, 100, []byte("x"))
+	if trieString(edited) != trieString(snaps[3].Text)[:100]+"x"+trieString(snaps[3].Text)[100:] {
+		t.Fatalf("a snapshot that was read does not edit")
+	}
+
+	p := all.Bytes()
+	p[len(p)/2]++
+	if _, err := ReadSnapshots(bytes.NewReader(p)); err != ErrSnapshotFormat {
+		t.Fatalf("ReadSnapshots of a damaged file: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+/* Memory accounting for tries.
+   Versions of a trie share most of their nodes, so adding up Size() over a
+   history of undo versions says very little about how much memory it holds.
+   Stats walks any number of roots, counts every node once, and reports how
+   many of them are shared and how well the leaves are packed compared to
+   what the concat strategy would aim for.
+ */
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"unsafe"
+)
+
+type TrieStats struct {
+	Roots    int `json:"roots"`
+	Nodes    int `json:"nodes"`    // unique nodes reachable from the roots
+	Shared   int `json:"shared"`   // nodes reachable through more than one path
+	Leaves   int `json:"leaves"`   // unique leaves
+	Height   int `json:"height"`   // height of the tallest root
+	Elements int `json:"elements"` // elements held in the unique leaves
+	Logical  int `json:"logical"`  // sum of the sizes of the roots
+
+	// LeafBytes is what the [m]T arrays of the unique leaves take up,
+	// UsedBytes the part of that holding elements, and Bytes an estimate for
+	// every unique node including the inner ones.
+	LeafBytes int `json:"leaf_bytes"`
+	UsedBytes int `json:"used_bytes"`
+	Bytes     int `json:"bytes"`
+
+	// Fill is Elements / (Leaves*m). Plan is the number of leaves the
+	// strategy plan would pack Elements into, over Leaves. Both are 1 for a
+	// perfectly packed trie.
+	Fill float64 `json:"fill"`
+	Plan float64 `json:"plan"`
+}
+
+// planned_leaves is the least number of leaves that can hold n elements,
+// following strategy where it has a plan for n.
+func planned_leaves(n int) int {
+	if n < len(strategy) {
+		p := strategy[n]
+		leaves := p.ms + p.mo
+		if p.lf > 0 {
+			leaves++
+		}
+		return leaves
+	}
+	return (n + m-1) / m
+}
+
+// Stats reports on the memory held by roots, counting shared nodes once.
+func Stats[T any](roots ...*Trie[T]) TrieStats {
+	var zero T
+	elem := int(unsafe.Sizeof(zero))
+	node := int(unsafe.Sizeof(Trie[T]{}))
+	inner := int(unsafe.Sizeof([m]*Trie[T]{}) + unsafe.Sizeof([m]int{}))
+
+	s := TrieStats{Roots: len(roots)}
+	seen := map[*Trie[T]]bool{}   // every node we counted
+	shared := map[*Trie[T]]bool{} // nodes known to have more than one path
+
+	// everything under a node with two paths to it has two paths as well
+	var mark func(t *Trie[T])
+	mark = func(t *Trie[T]) {
+		if shared[t] {
+			return
+		}
+		shared[t] = true
+		s.Shared++
+		for i := 0; t.height > 0 && i < t.length; i++ {
+			mark(t.subtrie[i])
+		}
+	}
+
+	var walk func(t *Trie[T])
+	walk = func(t *Trie[T]) {
+		if seen[t] {
+			mark(t)
+			return
+		}
+		seen[t] = true
+		s.Nodes++
+		if t.height == 0 {
+			s.Leaves++
+			s.Elements += t.length
+			s.LeafBytes += m*elem
+			s.UsedBytes += t.length*elem
+			s.Bytes += node + m*elem
+			return
+		}
+		s.Bytes += node + inner
+		for i := 0; i < t.length; i++ {
+			walk(t.subtrie[i])
+		}
+	}
+
+	for _, r := range roots {
+		if r == nil {
+			continue
+		}
+		s.Logical += r.Size()
+		if r.height > s.Height {
+			s.Height = r.height
+		}
+		walk(r)
+	}
+
+	if s.Leaves > 0 {
+		s.Fill = float64(s.Elements) / float64(s.Leaves*m)
+		s.Plan = float64(planned_leaves(s.Elements)) / float64(s.Leaves)
+	}
+	return s
+}
+
+// StatsHandler serves Stats over the roots returned by roots as JSON, for the
+// server to mount wherever it keeps its monitoring endpoints. web-lsp mounts
+// it at /debug/tries when it is started with -stats.
+func StatsHandler[T any](roots func() []*Trie[T]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// encode first, so a failure can still be told with a status
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(Stats(roots()...)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatsSingle(t *testing.T) {
+	a := TrieFromSlice[byte](randSeq(m*m))
+	s := Stats(a)
+	if s.Leaves != m || s.Nodes != m+1 || s.Shared != 0 || s.Height != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if s.Elements != m*m || s.Logical != m*m || s.UsedBytes != m*m || s.LeafBytes != m*m {
+		t.Fatalf("unexpected element counts %+v", s)
+	}
+	if s.Fill != 1 || s.Plan != 1 {
+		t.Fatalf("a full trie has fill %f plan %f", s.Fill, s.Plan)
+	}
+}
+
+func TestStatsHistory(t *testing.T) {
+	a := TrieFromSlice[byte](randSeq(3000))
+	versions := []*Trie[byte]{a}
+	for i := 0; i < 10; i++ {
+		versions = append(versions, versions[i].AppendSlice(randSeq(7)))
+	}
+	one := Stats(a)
+	s := Stats(versions...)
+	if s.Roots != len(versions) {
+		t.Fatalf("s.Roots = %d", s.Roots)
+	}
+	if s.Shared == 0 {
+		t.Fatalf("no shared nodes between versions")
+	}
+	if s.Nodes >= len(versions)*one.Nodes/2 {
+		t.Fatalf("%d unique nodes for %d versions of %d nodes",
+			s.Nodes, len(versions), one.Nodes)
+	}
+	if s.Logical <= s.Elements {
+		t.Fatalf("logical %d should be more than held %d", s.Logical, s.Elements)
+	}
+	if s.Fill <= 0 || s.Fill > 1 {
+		t.Fatalf("s.Fill = %f", s.Fill)
+	}
+
+	again := Stats(a, a)
+	if again.Nodes != one.Nodes || again.Shared != one.Nodes {
+		t.Fatalf("the same root twice: %+v", again)
+	}
+}
+
+func TestStatsEmpty(t *testing.T) {
+	s := Stats[byte]()
+	if s.Nodes != 0 || s.Fill != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	s = Stats(NewTrie[byte](0), nil)
+	if s.Nodes != 1 || s.Leaves != 1 || s.Elements != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	a := TrieFromSlice[byte](randSeq(500))
+	h := StatsHandler(func() []*Trie[byte] { return []*Trie[byte]{a} })
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/stats", nil))
+	var s TrieStats
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("bad response %q: %v", w.Body.String(), err)
+	}
+	if s != Stats(a) {
+		t.Fatalf("served %+v != %+v", s, Stats(a))
+	}
+}
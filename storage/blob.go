@@ -0,0 +1,116 @@
+/* A BlobStore keeps blobs of bytes by the SHA-256 of their contents, and
+   nothing else, which is about the simplest storage there is: any key value
+   store, a directory, or a bucket in the cloud will do.
+   Objects go into a BlobStore as their kind followed by their data, so the
+   name of a blob is the name of the object in it, see HashObject. Trie
+   nodes written through BlobObjects by different documents are the same
+   blob when they hold the same text, and are stored once.
+ */
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+	"sync"
+)
+
+type BlobStore interface {
+	// Put stores p, and returns its hash. Putting a blob that is there
+	// already does nothing.
+	Put(p []byte) (Hash, error)
+	Get(h Hash) ([]byte, error)
+	Has(h Hash) (bool, error)
+	// Delete removes the blob, if it is there.
+	Delete(h Hash) error
+	// List calls f with the hash of every blob, until f returns an error.
+	List(f func(Hash) error) error
+}
+
+// BlobObjects returns bs as a place for objects.
+func BlobObjects(bs BlobStore) interface{ ObjectReader; ObjectWriter } {
+	return blob_objects{bs}
+}
+
+type blob_objects struct {
+	bs BlobStore
+}
+
+func (o blob_objects)Get(h Hash) (Kind, []byte, error) {
+	p, err := o.bs.Get(h)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(p) == 0 {
+		return 0, nil, ErrCorrupt
+	}
+	return Kind(p[0]), p[1:], nil
+}
+
+func (o blob_objects)Has(h Hash) (bool, error) {
+	return o.bs.Has(h)
+}
+
+func (o blob_objects)Put(kind Kind, data []byte) (Hash, error) {
+	return o.bs.Put(append([]byte{byte(kind)}, data...))
+}
+
+type MemoryBlobs struct {
+	mu    sync.RWMutex
+	blobs map[Hash][]byte
+}
+
+func NewMemoryBlobs() *MemoryBlobs {
+	return &MemoryBlobs{blobs: map[Hash][]byte{}}
+}
+
+func (m *MemoryBlobs)Put(p []byte) (Hash, error) {
+	h := Hash(sha256.Sum256(p))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[h]; !ok {
+		m.blobs[h] = bytes.Clone(p)
+	}
+	return h, nil
+}
+
+func (m *MemoryBlobs)Get(h Hash) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.blobs[h]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(p), nil
+}
+
+func (m *MemoryBlobs)Has(h Hash) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.blobs[h]
+	return ok, nil
+}
+
+func (m *MemoryBlobs)Delete(h Hash) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, h)
+	return nil
+}
+
+// List goes through the blobs in order of their hashes.
+func (m *MemoryBlobs)List(f func(Hash) error) error {
+	m.mu.RLock()
+	hashes := make([]Hash, 0, len(m.blobs))
+	for h := range m.blobs {
+		hashes = append(hashes, h)
+	}
+	m.mu.RUnlock()
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+	for _, h := range hashes {
+		if err := f(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
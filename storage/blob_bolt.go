@@ -0,0 +1,109 @@
+/* BoltBlobs keeps blobs in one bucket of a bbolt database, an embedded key
+   value store in a single file, keyed by their hashes. Every Put is its own
+   transaction, so use PutAll to store many blobs with one sync.
+ */
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var blob_bucket = []byte("blobs")
+
+type BoltBlobs struct {
+	db *bolt.DB
+}
+
+// OpenBoltBlobs opens the database at path, or makes it.
+func OpenBoltBlobs(path string) (*BoltBlobs, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 5*time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(blob_bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltBlobs{db}, nil
+}
+
+func (bb *BoltBlobs)Put(p []byte) (Hash, error) {
+	hs, err := bb.PutAll([][]byte{p})
+	if err != nil {
+		return Hash{}, err
+	}
+	return hs[0], nil
+}
+
+// PutAll stores every blob in ps in one transaction.
+func (bb *BoltBlobs)PutAll(ps [][]byte) ([]Hash, error) {
+	hs := make([]Hash, len(ps))
+	err := bb.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(blob_bucket)
+		for i, p := range ps {
+			hs[i] = sha256.Sum256(p)
+			if b.Get(hs[i][:]) != nil {
+				continue
+			}
+			if err := b.Put(hs[i][:], p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return hs, err
+}
+
+func (bb *BoltBlobs)Get(h Hash) ([]byte, error) {
+	var p []byte
+	err := bb.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(blob_bucket).Get(h[:])
+		if v == nil {
+			return ErrNotFound
+		}
+		// v is only good until the transaction ends
+		p = bytes.Clone(v)
+		return nil
+	})
+	return p, err
+}
+
+func (bb *BoltBlobs)Has(h Hash) (bool, error) {
+	var ok bool
+	err := bb.db.View(func(tx *bolt.Tx) error {
+		ok = tx.Bucket(blob_bucket).Get(h[:]) != nil
+		return nil
+	})
+	return ok, err
+}
+
+func (bb *BoltBlobs)Delete(h Hash) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(blob_bucket).Delete(h[:])
+	})
+}
+
+// List goes through the blobs in order of their hashes, in one read
+// transaction.
+func (bb *BoltBlobs)List(f func(Hash) error) error {
+	return bb.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(blob_bucket).ForEach(func(k, _ []byte) error {
+			if len(k) != len(Hash{}) {
+				return ErrCorrupt
+			}
+			return f(Hash(k))
+		})
+	})
+}
+
+func (bb *BoltBlobs)Close() error {
+	return bb.db.Close()
+}
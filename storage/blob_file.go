@@ -0,0 +1,128 @@
+/* FileBlobs keeps each blob in a file named by its hash, in a directory
+   named by the first byte of the hash, so no directory gets more than a
+   small share of them, the way git keeps its loose objects.
+   A blob is written to a temporary file and renamed into place, so it is
+   whole or not there. Blobs are checked against their hash as they are
+   read.
+ */
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type FileBlobs struct {
+	dir string
+}
+
+// OpenFileBlobs keeps blobs under dir, which is made if it is not there.
+func OpenFileBlobs(dir string) (*FileBlobs, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileBlobs{dir}, nil
+}
+
+func (fb *FileBlobs)path(h Hash) string {
+	s := h.String()
+	return filepath.Join(fb.dir, s[:2], s[2:])
+}
+
+func (fb *FileBlobs)Put(p []byte) (Hash, error) {
+	h := Hash(sha256.Sum256(p))
+	path := fb.path(h)
+	if _, err := os.Stat(path); err == nil {
+		return h, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return h, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "tmp-")
+	if err != nil {
+		return h, err
+	}
+	_, err = f.Write(p)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return h, err
+}
+
+func (fb *FileBlobs)Get(h Hash) ([]byte, error) {
+	p, err := os.ReadFile(fb.path(h))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if Hash(sha256.Sum256(p)) != h {
+		return nil, ErrCorrupt
+	}
+	return p, nil
+}
+
+func (fb *FileBlobs)Has(h Hash) (bool, error) {
+	_, err := os.Stat(fb.path(h))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (fb *FileBlobs)Delete(h Hash) error {
+	err := os.Remove(fb.path(h))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List goes through the blobs in order of their hashes. Files that are not
+// named like blobs, such as temporary ones, are skipped.
+func (fb *FileBlobs)List(f func(Hash) error) error {
+	shards, err := os.ReadDir(fb.dir)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() || len(shard.Name()) != 2 {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(fb.dir, shard.Name()))
+		if err != nil {
+			return err
+		}
+		var hashes []Hash
+		for _, e := range entries {
+			p, err := hex.DecodeString(shard.Name()+e.Name())
+			if err != nil || len(p) != len(Hash{}) {
+				continue
+			}
+			hashes = append(hashes, Hash(p))
+		}
+		sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+		for _, h := range hashes {
+			if err := f(h); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
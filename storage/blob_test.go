@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func testBlobStore(t *testing.T, bs BlobStore) {
+	blobs := [][]byte{[]byte("hello"), []byte("world"), {}}
+	var hashes []Hash
+	for _, p := range blobs {
+		h, err := bs.Put(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h != sha256.Sum256(p) {
+			t.Fatalf("Put(%q) = %v", p, h)
+		}
+		// again, which does nothing
+		bs.Put(p)
+		hashes = append(hashes, h)
+	}
+	for i, h := range hashes {
+		if p, err := bs.Get(h); err != nil || !bytes.Equal(p, blobs[i]) {
+			t.Fatalf("Get(%v) = %q, %v", h, p, err)
+		}
+		if ok, err := bs.Has(h); !ok || err != nil {
+			t.Fatalf("Has(%v) = %v, %v", h, ok, err)
+		}
+	}
+
+	if err := bs.Delete(hashes[0]); err != nil {
+		t.Fatal(err)
+	}
+	bs.Delete(hashes[0])
+	if _, err := bs.Get(hashes[0]); err != ErrNotFound {
+		t.Fatalf("Get of a deleted blob: %v", err)
+	}
+	if ok, _ := bs.Has(hashes[0]); ok {
+		t.Fatalf("Has of a deleted blob")
+	}
+	var listed []Hash
+	bs.List(func(h Hash) error {
+		listed = append(listed, h)
+		return nil
+	})
+	if len(listed) != 2 || bytes.Compare(listed[0][:], listed[1][:]) >= 0 {
+		t.Fatalf("List gave %v", listed)
+	}
+	for _, h := range listed {
+		if h == hashes[0] {
+			t.Fatalf("List gave a deleted blob")
+		}
+	}
+}
+
+func TestMemoryBlobs(t *testing.T) {
+	testBlobStore(t, NewMemoryBlobs())
+}
+
+func TestFileBlobs(t *testing.T) {
+	dir := t.TempDir()
+	fb, err := OpenFileBlobs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, fb)
+
+	// sharded by the first byte of the hash
+	h, _ := fb.Put([]byte("sharded"))
+	s := h.String()
+	if _, err := fb.Get(h); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, s[:2], s[2:])
+	if fb.path(h) != path {
+		t.Fatalf("blob at %s, want %s", fb.path(h), path)
+	}
+}
+
+func TestBoltBlobs(t *testing.T) {
+	bb, err := OpenBoltBlobs(filepath.Join(t.TempDir(), "blobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bb.Close()
+	testBlobStore(t, bb)
+}
+
+// counting_blobs counts the blobs actually stored
+type counting_blobs struct {
+	BlobStore
+	stored int
+}
+
+func (c *counting_blobs)Put(p []byte) (Hash, error) {
+	if ok, _ := c.Has(sha256.Sum256(p)); !ok {
+		c.stored++
+	}
+	return c.BlobStore.Put(p)
+}
+
+// two documents with the same text in them share their nodes
+func TestBlobDedupe(t *testing.T) {
+	bs := &counting_blobs{BlobStore: NewMemoryBlobs()}
+	objects := BlobObjects(bs)
+	text := randomTrie(rand.New(rand.NewSource(2)), 5000)
+
+	a, err := WriteText(objects, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := bs.stored
+	// a copy made apart from the first, and the same text cut short
+	copied := trieOf(string(trieBytes(text)))
+	b, _ := WriteText(objects, copied)
+	c, _ := WriteText(objects, copied.Take(0, 4000))
+	if a != b || bs.stored-first > 5 {
+		t.Fatalf("the second document stored %d more blobs, the first %d", bs.stored-first, first)
+	}
+	got, err := ReadText(objects, c)
+	if err != nil || !bytes.Equal(trieBytes(got), trieBytes(text)[:4000]) {
+		t.Fatalf("ReadText: %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+/* Git keeps a project in a git repository, so its history can be looked at,
+   branched and pushed with the tools everyone already has, and a repository
+   that already exists can be opened and edited as a project.
+   Each commit is a batch of changes: the new text of every document that
+   changed goes in as a blob, the trees are the ones of the commit before
+   with those blobs swapped in, and the commit gets the author, time and
+   message of the batch. The revisions a batch covers are listed at the end
+   of the message, one line per document, with the path quoted as in Go if
+   it has a space or anything else in it that would need it.
+   The repository is worked on through the git command, with plumbing only:
+   fast-import to write, ls-tree and cat-file to read. Nothing goes over the
+   network, and a commit only moves a branch, never an index or working
+   tree. A branch that is checked out is refused, as git refuses a push to
+   one: its working tree would be left behind, with the commit showing in it
+   as a staged revert for the next git commit to make. In a repository with
+   a working tree SetBranch picks another branch to commit to.
+ */
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"web"
+)
+
+var (
+	ErrGitConflict   = errors.New("storage: branch moved during commit")
+	ErrGitCheckedOut = errors.New("storage: branch is checked out")
+	ErrSignature     = errors.New("storage: bad signature")
+)
+
+type Git struct {
+	dir    string // the git directory, .git or a bare repository
+	mu     sync.Mutex
+	target string // set by SetBranch, "" for the branch HEAD points to
+}
+
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// GitCommit is a batch of changes to commit.
+type GitCommit struct {
+	Author  Signature
+	Message string
+	Changes []Change
+	// Deleted are the paths of documents that are gone
+	Deleted []string
+}
+
+// InitGit makes a bare repository in dir, unless there is one, and opens it.
+func InitGit(dir string) (*Git, error) {
+	if _, err := os.Stat(dir); err == nil {
+		if g, err := OpenGit(dir); err == nil {
+			return g, nil
+		}
+	}
+	if out, err := exec.Command("git", "init", "--quiet", "--bare", dir).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("storage: git init: %v: %s", err, out)
+	}
+	return OpenGit(dir)
+}
+
+// OpenGit opens the repository at dir, which is a bare repository, a .git
+// directory or a working tree with one.
+func OpenGit(dir string) (*Git, error) {
+	cmd := exec.Command("git", "rev-parse", "--absolute-git-dir")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("storage: %s is not a git repository", dir)
+	}
+	return &Git{dir: strings.TrimSpace(string(out))}, nil
+}
+
+// git runs a git command on the repository, with stdin as its input, and
+// returns what it wrote
+func (g *Git)git(stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"--git-dir", g.dir}, args...)...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("storage: git %s: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// Branch returns the branch commits go to, such as refs/heads/main: the one
+// HEAD points to, unless SetBranch has picked another.
+func (g *Git)Branch() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.branch()
+}
+
+func (g *Git)branch() (string, error) {
+	if g.target != "" {
+		return g.target, nil
+	}
+	out, err := g.git(nil, "symbolic-ref", "HEAD")
+	return strings.TrimSpace(string(out)), err
+}
+
+// SetBranch makes commits go to branch, such as refs/heads/web. A branch
+// that isn't there yet starts at the commit HEAD is at.
+func (g *Git)SetBranch(branch string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := g.git(nil, "check-ref-format", branch); err != nil || !strings.HasPrefix(branch, "refs/heads/") {
+		return fmt.Errorf("storage: %q is not a branch", branch)
+	}
+	if _, err := g.git(nil, "rev-parse", "--verify", "--quiet", branch); err != nil {
+		out, err := g.git(nil, "rev-parse", "--verify", "--quiet", "HEAD")
+		// with no commit at HEAD, the first commit starts the branch
+		if head := strings.TrimSpace(string(out)); err == nil && head != "" {
+			if _, err := g.git(nil, "update-ref", branch, head, ""); err != nil {
+				return err
+			}
+		}
+	}
+	g.target = branch
+	return nil
+}
+
+// checked_out returns whether branch is checked out in a working tree of
+// the repository
+func (g *Git)checked_out(branch string) (bool, error) {
+	out, err := g.git(nil, "worktree", "list", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "branch "+branch {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Head returns the commit at the tip of the branch, "" when there is none
+// yet.
+func (g *Git)Head() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.head()
+}
+
+func (g *Git)head() (string, error) {
+	branch, err := g.branch()
+	if err != nil {
+		return "", err
+	}
+	out, err := g.git(nil, "rev-parse", "--verify", "--quiet", branch)
+	if err != nil {
+		// a branch with no commits
+		return "", nil
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// quote_path quotes a path for fast-import, the way git quotes them
+func quote_path(p string) string {
+	if !strings.ContainsAny(p, "\"\\\n") {
+		return p
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(p) + `"`
+}
+
+// trailer_path quotes a path for the Revisions trailer, when it has to be
+func trailer_path(p string) string {
+	if q := strconv.Quote(p); q[1:len(q)-1] != p || strings.ContainsAny(p, " ") {
+		return q
+	}
+	return p
+}
+
+// check checks that s goes into a commit header as it is
+func (s Signature)check() error {
+	if s.Name == "" || strings.ContainsAny(s.Name, "<>\n\x00") {
+		return fmt.Errorf("%w: name %q", ErrSignature, s.Name)
+	}
+	if strings.ContainsAny(s.Email, "<>\n\x00") {
+		return fmt.Errorf("%w: email %q", ErrSignature, s.Email)
+	}
+	if s.When.IsZero() || s.When.Unix() < 0 {
+		return fmt.Errorf("%w: time %v", ErrSignature, s.When)
+	}
+	return nil
+}
+
+func write_data(w *bytes.Buffer, p []byte) {
+	fmt.Fprintf(w, "data %d\n", len(p))
+	w.Write(p)
+	w.WriteByte('\n')
+}
+
+func fast_import_date(t time.Time) string {
+	_, offset := t.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%d %c%02d%02d", t.Unix(), sign, offset/3600, offset/60%60)
+}
+
+// modes returns the modes of paths in commit, so executables stay that way
+func (g *Git)modes(commit string, paths []string) (map[string]string, error) {
+	out := map[string]string{}
+	if commit == "" || len(paths) == 0 {
+		return out, nil
+	}
+	p, err := g.git(nil, append([]string{"ls-tree", "-z", "--full-tree", commit, "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range bytes.Split(p, []byte{0}) {
+		meta, path, ok := bytes.Cut(line, []byte{'\t'})
+		if fields := strings.Fields(string(meta)); ok && len(fields) == 3 && fields[1] == "blob" {
+			out[string(path)] = fields[0]
+		}
+	}
+	return out, nil
+}
+
+// Commit commits c on top of the branch, and returns the new commit. It
+// fails with ErrGitConflict if someone else moved the branch meanwhile,
+// with ErrGitCheckedOut if the branch is checked out, and with ErrSignature
+// if the author can't go into a commit as it is.
+func (g *Git)Commit(c GitCommit) (string, error) {
+	if err := c.Author.check(); err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	branch, err := g.branch()
+	if err != nil {
+		return "", err
+	}
+	if out, err := g.checked_out(branch); err != nil {
+		return "", err
+	} else if out {
+		return "", fmt.Errorf("%w: %s", ErrGitCheckedOut, branch)
+	}
+	parent, err := g.head()
+	if err != nil {
+		return "", err
+	}
+	paths := make([]string, len(c.Changes))
+	for i, ch := range c.Changes {
+		paths[i] = ch.Path
+	}
+	modes, err := g.modes(parent, paths)
+	if err != nil {
+		return "", err
+	}
+
+	msg := strings.TrimRight(c.Message, "\n")+"\n"
+	var trailers []string
+	for _, ch := range c.Changes {
+		if len(ch.Revs) > 0 {
+			trailers = append(trailers, fmt.Sprintf("Revisions: %s %d-%d", trailer_path(ch.Path), ch.Revs[0].Rev, ch.Revs[len(ch.Revs)-1].Rev))
+		}
+	}
+	if len(trailers) > 0 {
+		msg += "\n"+strings.Join(trailers, "\n")+"\n"
+	}
+
+	var in bytes.Buffer
+	who := fmt.Sprintf("%s <%s> %s", c.Author.Name, c.Author.Email, fast_import_date(c.Author.When))
+	fmt.Fprintf(&in, "commit %s\nauthor %s\ncommitter %s\n", branch, who, who)
+	write_data(&in, []byte(msg))
+	if parent != "" {
+		fmt.Fprintf(&in, "from %s\n", parent)
+	}
+	for _, ch := range c.Changes {
+		mode := modes[ch.Path]
+		if mode == "" {
+			mode = "100644"
+		}
+		text := make([]byte, ch.Text.Size())
+		ch.Text.ReadInto(0, text)
+		fmt.Fprintf(&in, "M %s inline %s\n", mode, quote_path(ch.Path))
+		write_data(&in, text)
+	}
+	for _, p := range c.Deleted {
+		fmt.Fprintf(&in, "D %s\n", quote_path(p))
+	}
+	in.WriteString("done\n")
+
+	// fast-import refuses to move the branch anywhere but forward from
+	// where it was, which catches a commit made around us
+	if _, err := g.git(&in, "fast-import", "--quiet", "--done"); err != nil {
+		if now, _ := g.head(); now != parent {
+			return "", ErrGitConflict
+		}
+		return "", err
+	}
+	return g.head()
+}
+
+// Files returns the files in commit rev, by their slash separated paths.
+// Submodules and symbolic links are left out.
+func (g *Git)Files(rev string) (map[string][]byte, error) {
+	p, err := g.git(nil, "ls-tree", "-r", "-z", "--full-tree", rev)
+	if err != nil {
+		return nil, err
+	}
+	var paths, hashes []string
+	for _, line := range bytes.Split(p, []byte{0}) {
+		meta, path, ok := bytes.Cut(line, []byte{'\t'})
+		fields := strings.Fields(string(meta))
+		if !ok || len(fields) != 3 || fields[1] != "blob" || fields[0] == "120000" {
+			continue
+		}
+		paths = append(paths, string(path))
+		hashes = append(hashes, fields[2])
+	}
+	blobs, err := g.blobs(hashes)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte, len(paths))
+	for i, path := range paths {
+		out[path] = blobs[i]
+	}
+	return out, nil
+}
+
+// blobs reads the blobs named hashes with one cat-file
+func (g *Git)blobs(hashes []string) ([][]byte, error) {
+	p, err := g.git(strings.NewReader(strings.Join(hashes, "\n")+"\n"), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(bytes.NewReader(p))
+	out := make([][]byte, len(hashes))
+	for i := range hashes {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 || fields[1] != "blob" {
+			return nil, fmt.Errorf("storage: git cat-file: %s", strings.TrimSpace(header))
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		out[i] = make([]byte, size+1)
+		if _, err := io.ReadFull(r, out[i]); err != nil {
+			return nil, err
+		}
+		out[i] = out[i][:size]
+	}
+	return out, nil
+}
+
+// OpenGitProject opens commit rev of g as a project, "" for the tip of the
+// branch. Like OpenProject it only takes the files it knows the language
+// of, and skips dot directories.
+func OpenGitProject(reg *web.Registry, g *Git, rev string) (*web.Project, error) {
+	if rev == "" {
+		head, err := g.Head()
+		if err != nil {
+			return nil, err
+		}
+		rev = head
+	}
+	p := web.NewProject(reg)
+	if rev == "" {
+		return p, nil
+	}
+	files, err := g.Files(rev)
+	if err != nil {
+		return nil, err
+	}
+	for path, text := range files {
+		if strings.HasPrefix(path, ".") || strings.Contains(path, "/.") {
+			continue
+		}
+		if reg.Detect(path, web.TrieFromSlice(text)) != nil {
+			p.Add(path, text)
+		}
+	}
+	return p, nil
+}
+
+// CommitProject commits the documents at paths in p as they are now, and
+// the ones of paths that are no longer in p as deleted. The message gets a
+// Revisions line for each document, with the revision it is at.
+func (g *Git)CommitProject(p *web.Project, paths []string, author Signature, message string) (string, error) {
+	c := GitCommit{Author: author, Message: message}
+	for _, path := range paths {
+		d := p.Document(path)
+		if d == nil {
+			c.Deleted = append(c.Deleted, path)
+			continue
+		}
+		v := d.Current()
+		ch := Change{Path: path, Text: v.Text, Revs: []web.Revision{{Rev: v.Rev}}}
+		if l := p.Language(path); l != nil {
+			ch.Language = l.Name
+		}
+		c.Changes = append(c.Changes, ch)
+	}
+	return g.Commit(c)
+}
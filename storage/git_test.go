@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"web"
+)
+
+func needGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+}
+
+func gitOut(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@example.com",
+		"GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestGit(t *testing.T) {
+	needGit(t)
+	dir := filepath.Join(t.TempDir(), "web.git")
+	g, err := InitGit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head, err := g.Head(); head != "" || err != nil {
+		t.Fatalf("Head of a new repository = %q, %v", head, err)
+	}
+
+	ann := Signature{"Ann", "ann@example.com", time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("", 3600))}
+	first, err := g.Commit(GitCommit{
+		Author:  ann,
+		Message: "first",
+		Changes: []Change{
+			{Path: "a.go", Language: "go", Text: trieOf("package a\n"),
+				Revs: []web.Revision{{Rev: 1}, {Rev: 2}}},
+			{Path: "dir/b \"q\".md", Language: "markdown", Text: trieOf("# b\n"),
+				Revs: []web.Revision{{Rev: 4}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gitOut(t, dir, "log", "-1", "--format=%an <%ae> %ad%n%B", "--date=iso", first); got !=
+		"Ann <ann@example.com> 2024-03-01 12:00:00 +0100\nfirst\n\nRevisions: a.go 1-2\nRevisions: \"dir/b \\\"q\\\".md\" 4-4" {
+		t.Fatalf("commit is\n%s", got)
+	}
+
+	// a signature that would change the header it goes in is refused
+	for _, bad := range []Signature{
+		{"Ann\ncommitter Eve <eve@example.com> 0 +0000", "ann@example.com", ann.When},
+		{"Ann", "ann@example.com> 0 +0000\nfrom <x", ann.When},
+		{"", "ann@example.com", ann.When},
+		{"Ann", "ann@example.com", time.Time{}},
+	} {
+		if _, err := g.Commit(GitCommit{Author: bad, Message: "bad"}); !errors.Is(err, ErrSignature) {
+			t.Fatalf("Commit by %q: %v", bad, err)
+		}
+	}
+	if head, _ := g.Head(); head != first {
+		t.Fatalf("Head after bad signatures = %s", head)
+	}
+
+	second, err := g.Commit(GitCommit{
+		Author:  ann,
+		Message: "second",
+		Changes: []Change{{Path: "a.go", Text: trieOf("package a\n\nfunc A() {}\n")}},
+		Deleted: []string{"dir/b \"q\".md"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent := gitOut(t, dir, "rev-parse", second+"^"); parent != first {
+		t.Fatalf("parent of the second commit is %s, want %s", parent, first)
+	}
+	files, err := g.Files(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]byte{"a.go": []byte("package a\n"), "dir/b \"q\".md": []byte("# b\n")}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("Files(first) = %q", files)
+	}
+	files, _ = g.Files(second)
+	if len(files) != 1 || string(files["a.go"]) != "package a\n\nfunc A() {}\n" {
+		t.Fatalf("Files(second) = %q", files)
+	}
+
+	// opened again, it carries on from where it was
+	g, err = InitGit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := g.Head(); head != second {
+		t.Fatalf("Head = %s, want %s", head, second)
+	}
+}
+
+// a repository made with git opens as a project, and edits go back in as
+// commits on a branch of their own, leaving the one checked out alone
+func TestGitProject(t *testing.T) {
+	needGit(t)
+	root := t.TempDir()
+	gitOut(t, root, "init", "--quiet", "-b", "main")
+	for path, text := range map[string]string{
+		"a.go": "package a\n\nfunc A() {}\n",
+		"run.sh": "#!/bin/sh\n",
+		"notes.bin": "\x00\x01",
+		".github/x.go": "package x\n",
+	} {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		os.MkdirAll(filepath.Dir(full), 0o755)
+		mode := os.FileMode(0o644)
+		if strings.HasPrefix(text, "#!") {
+			mode = 0o755
+		}
+		os.WriteFile(full, []byte(text), mode)
+	}
+	gitOut(t, root, "add", ".")
+	gitOut(t, root, "commit", "--quiet", "-m", "start")
+
+	g, err := OpenGit(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch, _ := g.Branch(); branch != "refs/heads/main" {
+		t.Fatalf("Branch = %s", branch)
+	}
+	p, err := OpenGitProject(web.DefaultRegistry(), g, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Paths(); !reflect.DeepEqual(got, []string{"a.go", "run.sh"}) {
+		t.Fatalf("Paths = %v", got)
+	}
+	if _, err := p.Replace("a.go", 21, 0, []byte("println()")); err != nil {
+		t.Fatal(err)
+	}
+	p.Add("b.go", []byte("package a\n"))
+	bob := Signature{"Bob", "bob@example.com", time.Unix(1700000000, 0).UTC()}
+	if _, err := g.CommitProject(p, []string{"a.go"}, bob, "edit"); !errors.Is(err, ErrGitCheckedOut) {
+		t.Fatalf("commit to the branch checked out: %v", err)
+	}
+	if err := g.SetBranch("refs/heads/web"); err != nil {
+		t.Fatal(err)
+	}
+	head, err := g.CommitProject(p, []string{"a.go", "b.go", "run.sh"}, bob, "edit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gitOut(t, root, "log", "--format=%an %s", "web"); got != "Bob edit\na start" {
+		t.Fatalf("log is\n%s", got)
+	}
+	if got := gitOut(t, root, "log", "-1", "--format=%B", "web"); got != "edit\n\nRevisions: a.go 1-1\nRevisions: b.go 0-0\nRevisions: run.sh 0-0" {
+		t.Fatalf("message is\n%s", got)
+	}
+	if got := gitOut(t, root, "log", "--format=%an %s", "main"); got != "a start" {
+		t.Fatalf("main moved:\n%s", got)
+	}
+	if got := gitOut(t, root, "status", "--porcelain"); got != "" {
+		t.Fatalf("the working tree changed:\n%s", got)
+	}
+	files, _ := g.Files(head)
+	if string(files["a.go"]) != "package a\n\nfunc A() {println()}\n" || string(files["b.go"]) != "package a\n" {
+		t.Fatalf("Files = %q", files)
+	}
+	// the rest of the tree is as it was, modes and all
+	if got := gitOut(t, root, "ls-tree", "--format=%(objectmode) %(path)", "-r", head, "run.sh", ".github"); got !=
+		"100644 .github/x.go\n100755 run.sh" {
+		t.Fatalf("ls-tree:\n%s", got)
+	}
+
+	q, err := OpenGitProject(web.DefaultRegistry(), g, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := q.Paths(); !reflect.DeepEqual(got, []string{"a.go", "b.go", "run.sh"}) {
+		t.Fatalf("Paths after the commit = %v", got)
+	}
+}
@@ -0,0 +1,274 @@
+/* SQLite keeps a store in one file, with no server to run. Objects, ops,
+   pages and refs each get a table.
+   The schema is made by the migrations below, run in order. The number of
+   the last one run is the user_version of the database, so opening an older
+   database runs the ones it is missing, and a database from a newer version
+   of this package is refused rather than misread. Migrations are only ever
+   added to the end of the list; for now there is the one that makes it.
+   The database is in WAL mode, so a View does not wait for an Update. Updates
+   wait for each other here rather than fail with SQLITE_BUSY. Synchronous is
+   FULL, so a commit is on disk when Update returns: with NORMAL the last
+   commits before a power cut could be lost, which no other store here does.
+ */
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"web"
+)
+
+var ErrSchema = errors.New("storage: database is from a newer version")
+
+var migrations = []string{
+	`CREATE TABLE objects (
+		hash BLOB PRIMARY KEY,
+		kind INTEGER NOT NULL,
+		data BLOB NOT NULL
+	) WITHOUT ROWID;
+	CREATE TABLE pages (
+		path     TEXT PRIMARY KEY,
+		language TEXT NOT NULL,
+		rev      INTEGER NOT NULL,
+		file     BLOB NOT NULL,
+		updated  INTEGER NOT NULL
+	);
+	CREATE TABLE ops (
+		path   TEXT NOT NULL,
+		rev    INTEGER NOT NULL,
+		client TEXT NOT NULL,
+		op     BLOB NOT NULL,
+		PRIMARY KEY (path, rev)
+	) WITHOUT ROWID;
+	CREATE TABLE refs (
+		name TEXT PRIMARY KEY,
+		hash BLOB NOT NULL
+	);`,
+}
+
+type SQLite struct {
+	db     *sql.DB
+	update sync.Mutex
+}
+
+// OpenSQLite opens the database at path, or makes it, and brings its schema
+// up to date.
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000&_synchronous=FULL")
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLite{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLite)migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return ErrSchema
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("storage: migration %d: %w", i+1, err)
+		}
+		// pragmas take no parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLite)View(f func(Reader) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(sqlite_tx{tx})
+}
+
+func (s *SQLite)Update(f func(Tx) error) error {
+	s.update.Lock()
+	defer s.update.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(sqlite_tx{tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite)Close() error {
+	return s.db.Close()
+}
+
+type sqlite_tx struct {
+	tx *sql.Tx
+}
+
+func not_found(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (t sqlite_tx)Get(h Hash) (Kind, []byte, error) {
+	var kind Kind
+	var data []byte
+	err := t.tx.QueryRow("SELECT kind, data FROM objects WHERE hash = ?", h[:]).Scan(&kind, &data)
+	return kind, data, not_found(err)
+}
+
+func (t sqlite_tx)Has(h Hash) (bool, error) {
+	var n int
+	err := t.tx.QueryRow("SELECT count(*) FROM objects WHERE hash = ?", h[:]).Scan(&n)
+	return n > 0, err
+}
+
+func (t sqlite_tx)Put(kind Kind, data []byte) (Hash, error) {
+	h := HashObject(kind, data)
+	_, err := t.tx.Exec("INSERT OR IGNORE INTO objects (hash, kind, data) VALUES (?, ?, ?)", h[:], kind, data)
+	return h, err
+}
+
+func (t sqlite_tx)Ops(path string, after int) ([]web.Revision, error) {
+	rows, err := t.tx.Query("SELECT rev, client, op FROM ops WHERE path = ? AND rev > ? ORDER BY rev", path, after)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []web.Revision
+	for rows.Next() {
+		var r web.Revision
+		var op []byte
+		if err := rows.Scan(&r.Rev, &r.Client, &op); err != nil {
+			return nil, err
+		}
+		if err := r.Op.UnmarshalBinary(op); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (t sqlite_tx)AppendOps(path string, revs ...web.Revision) error {
+	if len(revs) == 0 {
+		return nil
+	}
+	var last int
+	if err := t.tx.QueryRow("SELECT coalesce(max(rev), 0) FROM ops WHERE path = ?", path).Scan(&last); err != nil {
+		return err
+	}
+	for _, r := range revs {
+		if r.Rev != last+1 {
+			return web.ErrRevision
+		}
+		op, err := r.Op.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err := t.tx.Exec("INSERT INTO ops (path, rev, client, op) VALUES (?, ?, ?, ?)", path, r.Rev, r.Client, op); err != nil {
+			return err
+		}
+		last = r.Rev
+	}
+	return nil
+}
+
+func scan_page(row interface{ Scan(...any) error }) (Page, error) {
+	var p Page
+	var file []byte
+	var updated int64
+	if err := row.Scan(&p.Path, &p.Language, &p.Rev, &file, &updated); err != nil {
+		return p, not_found(err)
+	}
+	if len(file) != len(p.File) {
+		return p, ErrCorrupt
+	}
+	copy(p.File[:], file)
+	p.Updated = time.Unix(0, updated)
+	return p, nil
+}
+
+func (t sqlite_tx)Page(path string) (Page, error) {
+	return scan_page(t.tx.QueryRow("SELECT path, language, rev, file, updated FROM pages WHERE path = ?", path))
+}
+
+func (t sqlite_tx)Pages() ([]Page, error) {
+	rows, err := t.tx.Query("SELECT path, language, rev, file, updated FROM pages ORDER BY path")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Page
+	for rows.Next() {
+		p, err := scan_page(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (t sqlite_tx)SetPage(p Page) error {
+	_, err := t.tx.Exec(`INSERT INTO pages (path, language, rev, file, updated) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET language = excluded.language, rev = excluded.rev,
+			file = excluded.file, updated = excluded.updated`,
+		p.Path, p.Language, p.Rev, p.File[:], p.Updated.UnixNano())
+	return err
+}
+
+func (t sqlite_tx)DeletePage(path string) error {
+	if _, err := t.tx.Exec("DELETE FROM ops WHERE path = ?", path); err != nil {
+		return err
+	}
+	_, err := t.tx.Exec("DELETE FROM pages WHERE path = ?", path)
+	return err
+}
+
+func (t sqlite_tx)Ref(name string) (Hash, error) {
+	var h Hash
+	var p []byte
+	if err := t.tx.QueryRow("SELECT hash FROM refs WHERE name = ?", name).Scan(&p); err != nil {
+		return h, not_found(err)
+	}
+	if len(p) != len(h) {
+		return h, ErrCorrupt
+	}
+	copy(h[:], p)
+	return h, nil
+}
+
+func (t sqlite_tx)SetRef(name string, h Hash) error {
+	_, err := t.tx.Exec("INSERT INTO refs (name, hash) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET hash = excluded.hash", name, h[:])
+	return err
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLite(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "web.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testStore(t, s)
+}
+
+func TestSQLiteSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.db")
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Update(func(tx Tx) error { return tx.SetRef("head", Hash{1}) })
+	s.Close()
+
+	// opened again, the schema is left alone
+	s, err = OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	s.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if version != len(migrations) {
+		t.Fatalf("user_version = %d", version)
+	}
+	s.View(func(r Reader) error {
+		if h, err := r.Ref("head"); err != nil || h != (Hash{1}) {
+			t.Fatalf("Ref after reopening: %v, %v", h, err)
+		}
+		return nil
+	})
+	s.db.Exec("PRAGMA user_version = 99")
+	s.Close()
+	if _, err := OpenSQLite(path); err != ErrSchema {
+		t.Fatalf("OpenSQLite of a newer database: %v", err)
+	}
+}
@@ -0,0 +1,399 @@
+/* Package storage keeps projects: their documents, the history of edits
+   to them, and what is known about each page.
+   Everything but the ops and the page records is an object, named by the
+   SHA-256 of its kind and contents, so the same thing is only ever stored
+   once. The text of a document is stored a trie node at a time, and since
+   the versions of a page share most of their nodes, so do the versions
+   stored. There are three kinds of object:
+	node  a trie node, a uvarint height and then the bytes of a leaf or the
+	      hashes of the children
+	file  a document, the hash of its root node
+	tree  a directory, per entry its kind, a uvarint length and the name,
+	      and the hash, sorted by name
+   A Store is any engine that can hold these. Everything is read in View and
+   written in Update, which is all or nothing, so several documents can be
+   committed at once.
+ */
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"web"
+)
+
+var (
+	ErrNotFound = errors.New("storage: not found")
+	ErrCorrupt  = errors.New("storage: corrupt object")
+)
+
+type Hash [sha256.Size]byte
+
+func (h Hash)String() string {
+	return hex.EncodeToString(h[:])
+}
+
+func ParseHash(s string) (Hash, error) {
+	var h Hash
+	p, err := hex.DecodeString(s)
+	if err != nil || len(p) != len(h) {
+		return h, fmt.Errorf("storage: bad hash %q", s)
+	}
+	copy(h[:], p)
+	return h, nil
+}
+
+type Kind byte
+
+const (
+	KindNode Kind = iota+1
+	KindFile
+	KindTree
+)
+
+func (k Kind)String() string {
+	switch k {
+	case KindNode:
+		return "node"
+	case KindFile:
+		return "file"
+	case KindTree:
+		return "tree"
+	}
+	return fmt.Sprintf("kind(%d)", byte(k))
+}
+
+// HashObject returns the name of the object of kind with data.
+func HashObject(kind Kind, data []byte) Hash {
+	d := sha256.New()
+	d.Write([]byte{byte(kind)})
+	d.Write(data)
+	var h Hash
+	d.Sum(h[:0])
+	return h
+}
+
+// Page is what is known about a page besides its text.
+type Page struct {
+	Path     string
+	Language string
+	// Rev is the revision of the document in File
+	Rev     int
+	File    Hash
+	Updated time.Time
+}
+
+type Reader interface {
+	// Get returns the object named h.
+	Get(h Hash) (Kind, []byte, error)
+	Has(h Hash) (bool, error)
+	// Ops returns the revisions of the document at path after rev, in order.
+	Ops(path string, after int) ([]web.Revision, error)
+	Page(path string) (Page, error)
+	// Pages returns every page, by path.
+	Pages() ([]Page, error)
+	// Ref returns the hash stored as name, a tree usually.
+	Ref(name string) (Hash, error)
+}
+
+type Tx interface {
+	Reader
+	// Put stores an object and returns its name.
+	Put(kind Kind, data []byte) (Hash, error)
+	// AppendOps adds revisions to the log of the document at path, which
+	// must follow on from the ones there.
+	AppendOps(path string, revs ...web.Revision) error
+	SetPage(p Page) error
+	// DeletePage removes the page and its ops. Objects stay.
+	DeletePage(path string) error
+	SetRef(name string, h Hash) error
+}
+
+// ObjectReader and ObjectWriter are all the object functions below need, so
+// they work on a Reader and a Tx, and on a BlobStore through BlobObjects.
+type ObjectReader interface {
+	Get(h Hash) (Kind, []byte, error)
+}
+
+type ObjectWriter interface {
+	Has(h Hash) (bool, error)
+	Put(kind Kind, data []byte) (Hash, error)
+}
+
+type Store interface {
+	View(f func(Reader) error) error
+	// Update runs f in a transaction, which is committed if f returns nil
+	// and rolled back if not.
+	Update(f func(Tx) error) error
+	Close() error
+}
+
+// WriteText stores the nodes of t that are not stored yet, and a file of it,
+// and returns the name of the file.
+func WriteText(tx ObjectWriter, t *web.Trie[byte]) (Hash, error) {
+	root, err := write_node(tx, t, map[*web.Trie[byte]]Hash{})
+	if err != nil {
+		return Hash{}, err
+	}
+	return tx.Put(KindFile, root[:])
+}
+
+func encode_node(t *web.Trie[byte], hash func(*web.Trie[byte]) (Hash, error)) ([]byte, error) {
+	height, content, children := t.Node()
+	data := binary.AppendUvarint(nil, uint64(height))
+	data = append(data, content...)
+	for _, c := range children {
+		h, err := hash(c)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, h[:]...)
+	}
+	return data, nil
+}
+
+func write_node(tx ObjectWriter, t *web.Trie[byte], done map[*web.Trie[byte]]Hash) (Hash, error) {
+	if h, ok := done[t]; ok {
+		return h, nil
+	}
+	data, err := encode_node(t, func(c *web.Trie[byte]) (Hash, error) {
+		return write_node(tx, c, done)
+	})
+	if err != nil {
+		return Hash{}, err
+	}
+	h := HashObject(KindNode, data)
+	if ok, err := tx.Has(h); err != nil {
+		return h, err
+	} else if !ok {
+		if _, err := tx.Put(KindNode, data); err != nil {
+			return h, err
+		}
+	}
+	done[t] = h
+	return h, nil
+}
+
+// ReadText reads the text of the file named h.
+func ReadText(r ObjectReader, h Hash) (*web.Trie[byte], error) {
+	kind, data, err := r.Get(h)
+	if err != nil {
+		return nil, err
+	}
+	if kind != KindFile || len(data) != len(Hash{}) {
+		return nil, ErrCorrupt
+	}
+	return read_node(r, Hash(data), map[Hash]*web.Trie[byte]{})
+}
+
+func read_node(r ObjectReader, h Hash, done map[Hash]*web.Trie[byte]) (*web.Trie[byte], error) {
+	if t, ok := done[h]; ok {
+		return t, nil
+	}
+	kind, data, err := r.Get(h)
+	if err != nil {
+		return nil, err
+	}
+	t, err := decode_node(kind, data, func(c Hash) (*web.Trie[byte], error) {
+		return read_node(r, c, done)
+	})
+	if err != nil {
+		return nil, err
+	}
+	done[h] = t
+	return t, nil
+}
+
+func decode_node(kind Kind, data []byte, child func(Hash) (*web.Trie[byte], error)) (*web.Trie[byte], error) {
+	height, n := binary.Uvarint(data)
+	if kind != KindNode || n <= 0 || height > 64 {
+		return nil, ErrCorrupt
+	}
+	data = data[n:]
+	var t *web.Trie[byte]
+	if height == 0 {
+		t = web.NewTrieNode(0, data, nil)
+	} else {
+		if len(data)%len(Hash{}) != 0 {
+			return nil, ErrCorrupt
+		}
+		var children []*web.Trie[byte]
+		for ; len(data) > 0; data = data[len(Hash{}):] {
+			c, err := child(Hash(data[:len(Hash{})]))
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, c)
+		}
+		t = web.NewTrieNode(int(height), nil, children)
+	}
+	if t == nil {
+		return nil, ErrCorrupt
+	}
+	return t, nil
+}
+
+// Entry is a file or a tree in a tree.
+type Entry struct {
+	Name string
+	Kind Kind
+	Hash Hash
+}
+
+// WriteTree stores a tree of entries, and returns its name.
+func WriteTree(tx ObjectWriter, entries []Entry) (Hash, error) {
+	entries = append([]Entry(nil), entries...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	var data []byte
+	for _, e := range entries {
+		data = append(data, byte(e.Kind))
+		data = binary.AppendUvarint(data, uint64(len(e.Name)))
+		data = append(data, e.Name...)
+		data = append(data, e.Hash[:]...)
+	}
+	return tx.Put(KindTree, data)
+}
+
+// ReadTree returns the entries of the tree named h.
+func ReadTree(r ObjectReader, h Hash) ([]Entry, error) {
+	kind, data, err := r.Get(h)
+	if err != nil {
+		return nil, err
+	}
+	if kind != KindTree {
+		return nil, ErrCorrupt
+	}
+	var out []Entry
+	for len(data) > 0 {
+		e := Entry{Kind: Kind(data[0])}
+		size, n := binary.Uvarint(data[1:])
+		if n <= 0 || size > uint64(len(data)) || 1+n+int(size)+len(e.Hash) > len(data) {
+			return nil, ErrCorrupt
+		}
+		data = data[1+n:]
+		e.Name, data = string(data[:size]), data[size:]
+		copy(e.Hash[:], data)
+		data = data[len(e.Hash):]
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// WriteProjectTree stores the trees of a directory of files, by their slash
+// separated paths, and returns the name of the root.
+func WriteProjectTree(tx ObjectWriter, files map[string]Hash) (Hash, error) {
+	dirs := map[string][]Entry{".": nil}
+	for p, h := range files {
+		dirs[path.Dir(p)] = append(dirs[path.Dir(p)], Entry{path.Base(p), KindFile, h})
+		for d := path.Dir(p); d != "."; d = path.Dir(d) {
+			if _, ok := dirs[d]; !ok {
+				dirs[d] = nil
+			}
+		}
+	}
+	// deepest first, so the trees of subdirectories are written before
+	// their parents
+	order := make([]string, 0, len(dirs))
+	for d := range dirs {
+		if d != "." {
+			order = append(order, d)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return strings.Count(order[i], "/") > strings.Count(order[j], "/")
+	})
+	for _, d := range order {
+		h, err := WriteTree(tx, dirs[d])
+		if err != nil {
+			return Hash{}, err
+		}
+		parent := path.Dir(d)
+		dirs[parent] = append(dirs[parent], Entry{path.Base(d), KindTree, h})
+	}
+	return WriteTree(tx, dirs["."])
+}
+
+// ReadProjectTree returns the files under the tree named h, by their slash
+// separated paths.
+func ReadProjectTree(r ObjectReader, h Hash) (map[string]Hash, error) {
+	out := map[string]Hash{}
+	var walk func(dir string, h Hash) error
+	walk = func(dir string, h Hash) error {
+		entries, err := ReadTree(r, h)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			switch e.Kind {
+			case KindFile:
+				out[path.Join(dir, e.Name)] = e.Hash
+			case KindTree:
+				if err := walk(path.Join(dir, e.Name), e.Hash); err != nil {
+					return err
+				}
+			default:
+				return ErrCorrupt
+			}
+		}
+		return nil
+	}
+	return out, walk("", h)
+}
+
+// Change is what a commit does to one document: its text as of the last of
+// Revs, which are appended to its log.
+type Change struct {
+	Path     string
+	Language string
+	Text     *web.Trie[byte]
+	Revs     []web.Revision
+}
+
+// Commit stores the changes to several documents, and a tree of every page
+// as the ref "head", all at once or not at all. It returns the tree.
+func Commit(s Store, changes []Change, now time.Time) (Hash, error) {
+	var root Hash
+	err := s.Update(func(tx Tx) error {
+		for _, c := range changes {
+			old, err := tx.Page(c.Path)
+			if err != nil && err != ErrNotFound {
+				return err
+			}
+			file, err := WriteText(tx, c.Text)
+			if err != nil {
+				return err
+			}
+			if err := tx.AppendOps(c.Path, c.Revs...); err != nil {
+				return err
+			}
+			rev := old.Rev
+			if len(c.Revs) > 0 {
+				rev = c.Revs[len(c.Revs)-1].Rev
+			}
+			if err := tx.SetPage(Page{c.Path, c.Language, rev, file, now}); err != nil {
+				return err
+			}
+		}
+		pages, err := tx.Pages()
+		if err != nil {
+			return err
+		}
+		files := map[string]Hash{}
+		for _, p := range pages {
+			files[p.Path] = p.File
+		}
+		if root, err = WriteProjectTree(tx, files); err != nil {
+			return err
+		}
+		return tx.SetRef("head", root)
+	})
+	return root, err
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"web"
+)
+
+func randomTrie(rng *rand.Rand, n int) *web.Trie[byte] {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte('a'+rng.Intn(26))
+	}
+	return web.TrieFromSlice(p)
+}
+
+func trieOf(s string) *web.Trie[byte] {
+	return web.TrieFromSlice([]byte(s))
+}
+
+func trieBytes(t *web.Trie[byte]) []byte {
+	p := make([]byte, t.Size())
+	t.ReadInto(0, p)
+	return p
+}
+
+// counting_tx counts the objects put through it
+type counting_tx struct {
+	Tx
+	puts int
+}
+
+func (c *counting_tx)Put(kind Kind, data []byte) (Hash, error) {
+	c.puts++
+	return c.Tx.Put(kind, data)
+}
+
+// testStore runs the tests every Store has to pass on s, which is empty.
+func testStore(t *testing.T, s Store) {
+	rng := rand.New(rand.NewSource(1))
+	text := randomTrie(rng, 3000)
+	// an older version, which shares all but the path to its end
+	older := text.Take(0, 2000)
+
+	var file, older_file Hash
+	var first, second int
+	err := s.Update(func(tx Tx) error {
+		c := &counting_tx{Tx: tx}
+		var err error
+		if file, err = WriteText(c, text); err != nil {
+			return err
+		}
+		first = c.puts
+		c.puts = 0
+		older_file, err = WriteText(c, older)
+		second = c.puts
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second*4 > first {
+		t.Fatalf("a version that shares its nodes put %d objects, the first %d", second, first)
+	}
+	s.View(func(r Reader) error {
+		for h, want := range map[Hash]*web.Trie[byte]{file: text, older_file: older} {
+			got, err := ReadText(r, h)
+			if err != nil || !bytes.Equal(trieBytes(got), trieBytes(want)) {
+				t.Fatalf("ReadText(%v): %v", h, err)
+			}
+		}
+		if _, _, err := r.Get(Hash{1}); err != ErrNotFound {
+			t.Fatalf("Get of nothing: %v", err)
+		}
+		return nil
+	})
+
+	// two documents committed together
+	now := time.Unix(1700000000, 0)
+	a := web.ReplaceOp(0, 0, 0, []byte("package a\n"))
+	b := web.ReplaceOp(0, 0, 0, []byte("# b\n"))
+	root, err := Commit(s, []Change{
+		{"src/a.go", "go", trieOf("package a\n"), []web.Revision{{Rev: 1, Client: "x", Op: a}}},
+		{"docs/b.md", "markdown", trieOf("# b\n"), []web.Revision{{Rev: 1, Client: "y", Op: b}}},
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a commit that fails halfway leaves nothing behind
+	c := web.ReplaceOp(10, 10, 0, []byte("func A() {}\n"))
+	_, err = Commit(s, []Change{
+		{"src/a.go", "go", trieOf("package a\nfunc A() {}\n"), []web.Revision{{Rev: 2, Client: "x", Op: c}}},
+		{"docs/b.md", "markdown", trieOf("# b\n"), []web.Revision{{Rev: 3, Client: "y", Op: b}}},
+	}, now.Add(time.Minute))
+	if err != web.ErrRevision {
+		t.Fatalf("Commit with a revision out of order: %v", err)
+	}
+	fail := errors.New("fail")
+	if err := s.Update(func(tx Tx) error {
+		tx.SetRef("head", Hash{})
+		return fail
+	}); err != fail {
+		t.Fatalf("Update returned %v", err)
+	}
+
+	s.View(func(r Reader) error {
+		if h, err := r.Ref("head"); err != nil || h != root {
+			t.Fatalf("head is %v, %v, want %v", h, err, root)
+		}
+		pages, err := r.Pages()
+		if err != nil || len(pages) != 2 || pages[0].Path != "docs/b.md" || pages[1].Rev != 1 || !pages[1].Updated.Equal(now) {
+			t.Fatalf("Pages() = %+v, %v", pages, err)
+		}
+		ops, err := r.Ops("src/a.go", 0)
+		if err != nil || len(ops) != 1 || ops[0].Client != "x" || ops[0].Op.String() != a.String() {
+			t.Fatalf("Ops = %v, %v", ops, err)
+		}
+		files, err := ReadProjectTree(r, root)
+		want := map[string]Hash{"src/a.go": pages[1].File, "docs/b.md": pages[0].File}
+		if err != nil || !reflect.DeepEqual(files, want) {
+			t.Fatalf("ReadProjectTree = %v, %v", files, err)
+		}
+		text, err := ReadText(r, files["docs/b.md"])
+		if err != nil || string(trieBytes(text)) != "# b\n" {
+			t.Fatalf("b.md reads %q, %v", trieBytes(text), err)
+		}
+		return nil
+	})
+
+	if err := s.Update(func(tx Tx) error { return tx.DeletePage("docs/b.md") }); err != nil {
+		t.Fatal(err)
+	}
+	s.View(func(r Reader) error {
+		if _, err := r.Page("docs/b.md"); err != ErrNotFound {
+			t.Fatalf("Page of a deleted page: %v", err)
+		}
+		if ops, _ := r.Ops("docs/b.md", 0); len(ops) != 0 {
+			t.Fatalf("a deleted page kept its ops")
+		}
+		return nil
+	})
+}
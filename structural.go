@@ -0,0 +1,215 @@
+/* Structural edits work on syntax nodes instead of characters: delete or
+   duplicate a node, swap it with a sibling, wrap a selection in a call, and
+   grow or shrink a selection by node.
+   Each one only works out which bytes to replace, as TextEdits, and leaves
+   making them to the caller. Document.Apply makes them all as one version,
+   so undo and collaboration see a single edit like any typed text.
+   The node an edit works on is the smallest named node that holds the
+   selection, so with the cursor on an argument it is the argument, and with a
+   statement selected it is the statement.
+ */
+package web
+
+import (
+	"bytes"
+	"errors"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// TextEdit replaces the bytes from Start to End with Text.
+type TextEdit struct {
+	Start, End int
+	Text       []byte
+}
+
+type StructuralEdit struct {
+	// Edits are in the positions of the version they were made for, in order
+	// and without overlaps.
+	Edits []TextEdit
+	// the selection once the edits are made
+	Start, End int
+}
+
+var ErrStaleEdits = errors.New("web: edits are for an older version")
+
+// Apply makes edits, which were worked out on the version with revision rev,
+// as one new version, so they are undone together and no version has only
+// some of them. It fails with ErrStaleEdits if d has moved on from rev, as
+// the positions of the edits no longer hold then. A document of a project
+// is edited with Project.Apply, so the index follows.
+func (d *Document)Apply(rev int, edits []TextEdit) (*Version, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cur := d.current()
+	if rev != cur.Rev {
+		return nil, ErrStaleEdits
+	}
+	if len(edits) == 0 {
+		return cur, nil
+	}
+	end, grown := 0, 0
+	for _, e := range edits {
+		if e.Start < end || e.End < e.Start || e.End > cur.Text.Size() {
+			return nil, ErrRange
+		}
+		end = e.End
+		grown += len(e.Text) - (e.End-e.Start)
+	}
+
+	// the last one first, so the positions of the others still hold
+	text := cur.Text
+	for i := len(edits)-1; i >= 0; i-- {
+		e := edits[i]
+		text = text.Delete(0, e.Start, e.End-e.Start).Insert(0, e.Start, e.Text)
+	}
+	// the tree gets one edit, from the start of the first to the end of the
+	// last
+	start := edits[0].Start
+	edit := EditFor(cur.Text, start, end-start, textRange(text, start, end+grown))
+	return d.next(cur, text, edit), nil
+}
+
+func textRange(t *Trie[byte], start, end int) []byte {
+	p := make([]byte, end-start)
+	t.ReadInto(start, p)
+	return p
+}
+
+// node_for returns the smallest named node of tree that holds start to end
+func node_for(v *Version, tree *sitter.Tree, start, end int) *sitter.Node {
+	return tree.RootNode().NamedDescendantForPointRange(PointAt(v.Text, start), PointAt(v.Text, end))
+}
+
+// node_at is node_for for the edits, which never work on the whole tree
+func node_at(v *Version, start, end int) *sitter.Node {
+	if v.Tree == nil {
+		return nil
+	}
+	n := node_for(v, v.Tree.Copy(), start, end)
+	if n == nil || n.Parent() == nil {
+		return nil
+	}
+	return n
+}
+
+// indent returns the white space before off on its line, and whether there
+// is nothing else before it
+func indent(t *Trie[byte], off int) ([]byte, bool) {
+	line := textRange(t, off-int(PointAt(t, off).Column), off)
+	return line, len(bytes.TrimLeft(line, " \t")) == 0
+}
+
+// DeleteNode deletes the node at the selection, along with what separates it
+// from its next sibling, or its previous one if it is the last, so that no
+// stray comma or blank line is left behind.
+func DeleteNode(v *Version, start, end int) (StructuralEdit, bool) {
+	n := node_at(v, start, end)
+	if n == nil {
+		return StructuralEdit{}, false
+	}
+	from, to := int(n.StartByte()), int(n.EndByte())
+	if next := n.NextNamedSibling(); next != nil {
+		to = int(next.StartByte())
+	} else if prev := n.PrevNamedSibling(); prev != nil {
+		from = int(prev.EndByte())
+	} else if _, alone := indent(v.Text, from); alone {
+		// the only thing on its line takes the line with it
+		rest := textRange(v.Text, to, min(to+read_chunk, v.Text.Size()))
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 && len(bytes.TrimSpace(rest[:i])) == 0 {
+			from -= int(PointAt(v.Text, from).Column)
+			to += i+1
+		}
+	}
+	return StructuralEdit{Edits: []TextEdit{{from, to, nil}}, Start: from, End: from}, true
+}
+
+// DuplicateNode puts a copy of the node at the selection after it, separated
+// the way it is from its siblings, and selects the copy.
+func DuplicateNode(v *Version, start, end int) (StructuralEdit, bool) {
+	n := node_at(v, start, end)
+	if n == nil {
+		return StructuralEdit{}, false
+	}
+	text := nodeText(v.Text, n)
+	var sep []byte
+	if next := n.NextNamedSibling(); next != nil {
+		sep = textRange(v.Text, int(n.EndByte()), int(next.StartByte()))
+	} else if prev := n.PrevNamedSibling(); prev != nil {
+		sep = textRange(v.Text, int(prev.EndByte()), int(n.StartByte()))
+	} else if ws, alone := indent(v.Text, int(n.StartByte())); alone {
+		sep = append([]byte("\n"), ws...)
+	} else {
+		sep = []byte(" ")
+	}
+	at := int(n.EndByte())
+	e := TextEdit{at, at, append(sep, text...)}
+	return StructuralEdit{Edits: []TextEdit{e}, Start: at+len(sep), End: at+len(sep)+len(text)}, true
+}
+
+// SwapSibling swaps the node at the selection with its next named sibling,
+// or its previous one when forward is false, and selects it where it ends
+// up.
+func SwapSibling(v *Version, start, end int, forward bool) (StructuralEdit, bool) {
+	n := node_at(v, start, end)
+	if n == nil {
+		return StructuralEdit{}, false
+	}
+	a, b := n, n.NextNamedSibling()
+	if !forward {
+		a, b = n.PrevNamedSibling(), n
+	}
+	if a == nil || b == nil {
+		return StructuralEdit{}, false
+	}
+	at, bt := nodeText(v.Text, a), nodeText(v.Text, b)
+	as, bs := int(a.StartByte()), int(b.StartByte())
+	e := StructuralEdit{Edits: []TextEdit{
+		{as, int(a.EndByte()), bt},
+		{bs, int(b.EndByte()), at},
+	}}
+	if forward {
+		e.Start = bs + len(bt) - len(at)
+		e.End = e.Start + len(at)
+	} else {
+		e.Start, e.End = as, as+len(bt)
+	}
+	return e, true
+}
+
+// WrapInCall turns the selection into the argument of a call to name, and
+// selects the call.
+func WrapInCall(start, end int, name string) StructuralEdit {
+	return StructuralEdit{
+		Edits: []TextEdit{
+			{start, start, []byte(name + "(")},
+			{end, end, []byte(")")},
+		},
+		Start: start,
+		End:   end + len(name) + 2,
+	}
+}
+
+// ExpandSelection grows the selection to the smallest named node around it,
+// see Enclosing.
+func ExpandSelection(v *Version, start, end int) (int, int, bool) {
+	r, ok := Enclosing(v, start, end)
+	return int(r.StartByte), int(r.EndByte), ok
+}
+
+// ShrinkSelection undoes ExpandSelection as far as the tree can tell: it
+// selects the first named child of the node the selection is, or is in.
+func ShrinkSelection(v *Version, start, end int) (int, int, bool) {
+	if v.Tree == nil {
+		return 0, 0, false
+	}
+	n := node_for(v, v.Tree.Copy(), start, end)
+	for n != nil && n.NamedChildCount() > 0 {
+		c := n.NamedChild(0)
+		if int(c.StartByte()) != start || int(c.EndByte()) != end {
+			return int(c.StartByte()), int(c.EndByte()), true
+		}
+		n = c
+	}
+	return 0, 0, false
+}
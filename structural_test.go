@@ -0,0 +1,128 @@
+package web
+
+import (
+	"strings"
+	"testing"
+)
+
+const swap_source = "package main\n\nfunc main() {\n\tf(a, b, c)\n}\n"
+
+// structural runs op with the selection marked by [ and ] in src, or the
+// cursor at |, and returns the text after it with the new selection marked
+func structural(t *testing.T, src string, op func(v *Version, start, end int) (StructuralEdit, bool)) string {
+	t.Helper()
+	start, end := strings.IndexAny(src, "[|"), strings.IndexAny(src, "]|")
+	if src[start] == '[' {
+		src = src[:start] + src[start+1:end] + src[end+1:]
+		end--
+	} else {
+		src = src[:start] + src[start+1:]
+	}
+	d := NewDocument(DefaultRegistry().Lookup("go").Grammar, []byte(src))
+	cur := d.Current()
+	e, ok := op(cur, start, end)
+	if !ok {
+		return "no edit"
+	}
+	v, err := d.Apply(cur.Rev, e.Edits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Rev != cur.Rev+1 {
+		t.Fatalf("%d edits made %d versions", len(e.Edits), v.Rev-cur.Rev)
+	}
+	text := string(Bytes(TrieBufferOf(v.Text)))
+	// the new tree is still a fresh parse of the text
+	fresh := NewDocument(DefaultRegistry().Lookup("go").Grammar, []byte(text))
+	if v.Tree.RootNode().String() != fresh.Current().Tree.RootNode().String() {
+		t.Fatalf("tree after the edits is not what %q parses to", text)
+	}
+	return text[:e.Start] + "[" + text[e.Start:e.End] + "]" + text[e.End:]
+}
+
+func TestStructuralEdits(t *testing.T) {
+	swap := func(forward bool) func(*Version, int, int) (StructuralEdit, bool) {
+		return func(v *Version, start, end int) (StructuralEdit, bool) {
+			return SwapSibling(v, start, end, forward)
+		}
+	}
+	// bodies of main, with the selection in [] or the cursor at |
+	cases := []struct {
+		src  string
+		op   func(*Version, int, int) (StructuralEdit, bool)
+		want string
+	}{
+		{"\tf(a, |b, c)\n", DeleteNode, "\tf(a, []c)\n"},
+		{"\tf(a, b, |c)\n", DeleteNode, "\tf(a, b[])\n"},
+		{"\tx := 1\n\t[y := 2]\n", DeleteNode, "\tx := 1[]\n"},
+		{"\t[y := 2]\n", DeleteNode, "[]"},
+		{"\tf(|a, b, c)\n", DuplicateNode, "\tf(a, [a], b, c)\n"},
+		{"\t[x := 1]\n", DuplicateNode, "\tx := 1\n\t[x := 1]\n"},
+		{"\tf(a, |b, c)\n", swap(true), "\tf(a, c, [b])\n"},
+		{"\tf(a, |b, c)\n", swap(false), "\tf([b], a, c)\n"},
+		{"\tf(a, b, |c)\n", swap(true), "no edit"},
+		{"\t[x := 1]\n\ty := 2\n", swap(true), "\ty := 2\n\t[x := 1]\n"},
+		{"\tf(a, [b], c)\n", func(v *Version, start, end int) (StructuralEdit, bool) {
+			return WrapInCall(start, end, "g"), true
+		}, "\tf(a, [g(b)], c)\n"},
+	}
+	for _, c := range cases {
+		got := structural(t, "package main\n\nfunc main() {\n"+c.src+"}\n", c.op)
+		if got != "no edit" {
+			got = strings.TrimSuffix(strings.TrimPrefix(got, "package main\n\nfunc main() {\n"), "}\n")
+		}
+		if got != c.want {
+			t.Errorf("%q: got %q, want %q", c.src, got, c.want)
+		}
+	}
+}
+
+// edits worked out on a version the document has moved on from are refused
+func TestApplyStale(t *testing.T) {
+	d := NewDocument(DefaultRegistry().Lookup("go").Grammar, []byte(swap_source))
+	v := d.Current()
+	at := strings.Index(swap_source, "b, c")
+	e, _ := SwapSibling(v, at, at+1, true)
+	if _, err := d.Insert(0, []byte("// x\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Apply(v.Rev, e.Edits); err != ErrStaleEdits {
+		t.Fatalf("Apply on a stale version: %v", err)
+	}
+	cur := d.Current()
+	overlapping := []TextEdit{{5, 10, nil}, {8, 12, nil}}
+	if _, err := d.Apply(cur.Rev, overlapping); err != ErrRange {
+		t.Fatalf("Apply of overlapping edits: %v", err)
+	}
+	if d.Current() != cur {
+		t.Fatalf("a refused Apply made a version")
+	}
+}
+
+func TestSelection(t *testing.T) {
+	d := NewDocument(DefaultRegistry().Lookup("go").Grammar, []byte(swap_source))
+	v := d.Current()
+	at := strings.Index(swap_source, "b, c")
+	start, end := at, at
+	var grown []string
+	for {
+		s, e, ok := ExpandSelection(v, start, end)
+		if !ok {
+			break
+		}
+		start, end = s, e
+		grown = append(grown, swap_source[s:e])
+	}
+	if grown[0] != "b" || grown[1] != "(a, b, c)" || grown[2] != "f(a, b, c)" {
+		t.Fatalf("ExpandSelection grows through %q", grown)
+	}
+	call := strings.Index(swap_source, "f(a")
+	s, e, ok := ShrinkSelection(v, call, call+len("f(a, b, c)"))
+	if !ok || swap_source[s:e] != "f" {
+		t.Fatalf("ShrinkSelection(f(a, b, c)) = %q", swap_source[s:e])
+	}
+	s, e, ok = ShrinkSelection(v, s, e)
+	if ok {
+		t.Fatalf("ShrinkSelection(f) = %q", swap_source[s:e])
+	}
+}
@@ -0,0 +1,354 @@
+/* The symbol index runs each language's tag query over every document and
+   keeps the definitions and references it finds, to answer "where is X
+   defined", "where is X used" and "what is in this file" for the wiki's
+   symbol links.
+   Names are matched as they are written, the way ctags does it. There are no
+   scopes or imports, so a local x is a reference to every x.
+   Symbols are kept per top level node of a document. After an edit any top
+   level node the edit did not touch keeps its symbols with their positions
+   shifted, see unchanged_tops, and only the rest is queried again.
+ */
+package web
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+type Symbol struct {
+	Name string
+	// Kind is what the tag query captured the definition as, "function",
+	// "method", "type", "heading" and so on.
+	Kind     string
+	Path     string
+	Language string
+	// Range covers the whole definition, NameRange just its name.
+	Range     sitter.Range
+	NameRange sitter.Range
+}
+
+// Reference is a use of a name somewhere outside its definitions.
+type Reference struct {
+	Name  string
+	Path  string
+	Range sitter.Range
+}
+
+// what the tag query found under one node
+type tagged struct {
+	symbols []Symbol
+	refs    []Reference
+}
+
+func (t tagged)shift(e sitter.EditInput) tagged {
+	out := tagged{make([]Symbol, len(t.symbols)), make([]Reference, len(t.refs))}
+	for i, s := range t.symbols {
+		s.Range = shift_range(s.Range, e)
+		s.NameRange = shift_range(s.NameRange, e)
+		out.symbols[i] = s
+	}
+	for i, r := range t.refs {
+		r.Range = shift_range(r.Range, e)
+		out.refs[i] = r
+	}
+	return out
+}
+
+type indexed_file struct {
+	lang *Language
+	rev  int
+	// our own copy of the version's tree, trees are not safe to share
+	tree *sitter.Tree
+	// what is under each top level node of tree
+	tops     []tagged
+	injected []tagged
+	queried  int // top level nodes the last update had to query
+}
+
+func (f *indexed_file)all() []tagged {
+	return append(append([]tagged(nil), f.tops...), f.injected...)
+}
+
+func (f *indexed_file)symbols() []Symbol {
+	var out []Symbol
+	for _, t := range f.all() {
+		out = append(out, t.symbols...)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Range.StartByte < out[j].Range.StartByte
+	})
+	return out
+}
+
+func (f *indexed_file)refs() []Reference {
+	var out []Reference
+	for _, t := range f.all() {
+		out = append(out, t.refs...)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Range.StartByte < out[j].Range.StartByte
+	})
+	return out
+}
+
+type Index struct {
+	reg    *Registry
+	update sync.Mutex // one Update at a time, readers only need mu
+	mu     sync.RWMutex
+	files  map[string]*indexed_file
+	byname map[string]map[string][]Symbol // name, then path
+	refs   map[string]map[string][]Reference
+}
+
+// NewIndex makes an index that looks into injections with the languages in
+// reg, which may be nil to skip them.
+func NewIndex(reg *Registry) *Index {
+	return &Index{
+		reg:    reg,
+		files:  map[string]*indexed_file{},
+		byname: map[string]map[string][]Symbol{},
+		refs:   map[string]map[string][]Reference{},
+	}
+}
+
+// tags runs lang's tag query over n and returns the definitions and
+// references under it
+func tags(lang *Language, path string, text *Trie[byte], n *sitter.Node) tagged {
+	q := lang.query(lang.Tags)
+	if q == nil {
+		return tagged{}
+	}
+	qc := sitter.NewQueryCursor()
+	defer qc.Close()
+	qc.Exec(q, n)
+
+	var out []Symbol
+	var refs []Reference
+	// definitions we have by their range, and the pattern that found them.
+	// when two patterns find the same one the later, more specific, wins
+	type found struct {
+		i       int
+		pattern uint16
+	}
+	seen := map[[2]uint32]found{}
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		s := Symbol{Path: path, Language: lang.Name}
+		var def *sitter.Node
+		for _, c := range m.Captures {
+			name := q.CaptureNameForId(c.Index)
+			if name == "reference" {
+				refs = append(refs, Reference{string(nodeText(text, c.Node)), path, c.Node.Range()})
+			} else if name == "name" {
+				s.Name = strings.TrimSpace(string(nodeText(text, c.Node)))
+				s.NameRange = c.Node.Range()
+			} else if kind, ok := strings.CutPrefix(name, "definition."); ok {
+				s.Kind = kind
+				s.Range = c.Node.Range()
+				def = c.Node
+			}
+		}
+		if def == nil || s.Name == "" {
+			continue
+		}
+		key := [2]uint32{def.StartByte(), def.EndByte()}
+		if f, ok := seen[key]; ok {
+			if m.PatternIndex > f.pattern {
+				out[f.i] = s
+				seen[key] = found{f.i, m.PatternIndex}
+			}
+			continue
+		}
+		seen[key] = found{len(out), m.PatternIndex}
+		out = append(out, s)
+	}
+
+	// the names of definitions are not references to them
+	names := map[uint32]bool{}
+	for _, s := range out {
+		names[s.NameRange.StartByte] = true
+	}
+	kept := refs[:0]
+	for _, r := range refs {
+		if !names[r.Range.StartByte] {
+			kept = append(kept, r)
+		}
+	}
+	return tagged{out, kept}
+}
+
+// Update indexes v, the latest version of the document at path. If the index
+// has the version before v it only looks at what the edit changed.
+func (ix *Index)Update(path string, lang *Language, v *Version) {
+	if lang == nil || v.Tree == nil {
+		ix.Remove(path)
+		return
+	}
+	ix.update.Lock()
+	defer ix.update.Unlock()
+
+	ix.mu.RLock()
+	old := ix.files[path]
+	ix.mu.RUnlock()
+
+	f := &indexed_file{lang: lang, rev: v.Rev, tree: v.Tree.Copy()}
+	reuse := map[top_level_key]tagged{}
+	if old != nil && old.lang == lang && old.rev == v.Rev-1 && v.Edit != nil {
+		for key, i := range unchanged_tops(old.tree, *v.Edit) {
+			reuse[key] = old.tops[i].shift(*v.Edit)
+		}
+	}
+
+	for _, c := range children(f.tree.RootNode()) {
+		t, ok := reuse[key_of(c)]
+		if !ok {
+			t = tags(lang, path, v.Text, c)
+			f.queried++
+		}
+		f.tops = append(f.tops, t)
+	}
+
+	// code blocks are small, they are indexed from scratch every time
+	if ix.reg != nil {
+		for _, inj := range ix.reg.Injections(lang, v) {
+			f.injected = append(f.injected,
+				tags(inj.Language, path, v.Text, inj.Tree.RootNode()))
+		}
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.unname(path)
+	ix.files[path] = f
+	for _, s := range f.symbols() {
+		if ix.byname[s.Name] == nil {
+			ix.byname[s.Name] = map[string][]Symbol{}
+		}
+		ix.byname[s.Name][path] = append(ix.byname[s.Name][path], s)
+	}
+	for _, r := range f.refs() {
+		if ix.refs[r.Name] == nil {
+			ix.refs[r.Name] = map[string][]Reference{}
+		}
+		ix.refs[r.Name][path] = append(ix.refs[r.Name][path], r)
+	}
+}
+
+// unname drops the symbols and references of path from byname and refs,
+// ix.mu must be held
+func (ix *Index)unname(path string) {
+	f := ix.files[path]
+	if f == nil {
+		return
+	}
+	for _, s := range f.symbols() {
+		delete(ix.byname[s.Name], path)
+		if len(ix.byname[s.Name]) == 0 {
+			delete(ix.byname, s.Name)
+		}
+	}
+	for _, r := range f.refs() {
+		delete(ix.refs[r.Name], path)
+		if len(ix.refs[r.Name]) == 0 {
+			delete(ix.refs, r.Name)
+		}
+	}
+}
+
+func (ix *Index)Remove(path string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.unname(path)
+	delete(ix.files, path)
+}
+
+// Symbols lists the symbols in the file at path in the order they appear.
+func (ix *Index)Symbols(path string) []Symbol {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	f := ix.files[path]
+	if f == nil {
+		return nil
+	}
+	return f.symbols()
+}
+
+// Definitions finds every definition of name, ordered by path and position.
+func (ix *Index)Definitions(name string) []Symbol {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var out []Symbol
+	for _, syms := range ix.byname[name] {
+		out = append(out, syms...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].Range.StartByte < out[j].Range.StartByte
+	})
+	return out
+}
+
+// References finds every use of name, ordered by path and position.
+func (ix *Index)References(name string) []Reference {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var out []Reference
+	for _, refs := range ix.refs[name] {
+		out = append(out, refs...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].Range.StartByte < out[j].Range.StartByte
+	})
+	return out
+}
+
+// NameAt returns the name that is defined or used at off in the file at
+// path. off may be just past the end of the name, where the cursor is after
+// typing it.
+func (ix *Index)NameAt(path string, off int) (string, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	f := ix.files[path]
+	if f == nil {
+		return "", false
+	}
+	in := func(r sitter.Range) bool {
+		return int(r.StartByte) <= off && off <= int(r.EndByte)
+	}
+	for _, t := range f.all() {
+		for _, s := range t.symbols {
+			if in(s.NameRange) {
+				return s.Name, true
+			}
+		}
+		for _, r := range t.refs {
+			if in(r.Range) {
+				return r.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Paths lists the indexed files.
+func (ix *Index)Paths() []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	out := make([]string, 0, len(ix.files))
+	for p := range ix.files {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const go_source = `package main
+
+type Point struct{ X, Y int }
+
+const Origin = 0
+
+func (p Point) Add(q Point) Point {
+	return Point{p.X + q.X, p.Y + q.Y}
+}
+
+func main() {
+	println(Origin)
+}
+`
+
+func names(syms []Symbol) []string {
+	var out []string
+	for _, s := range syms {
+		out = append(out, s.Kind+" "+s.Name)
+	}
+	return out
+}
+
+func TestTagQueries(t *testing.T) {
+	for _, l := range builtin_languages() {
+		if l.Tags != "" && l.query(l.Tags) == nil {
+			t.Errorf("%s: no tag query", l.Name)
+		}
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	r := DefaultRegistry()
+	ix := NewIndex(r)
+	d, l := r.Open("main.go", []byte(go_source))
+	ix.Update("main.go", l, d.Current())
+
+	want := []string{"type Point", "constant Origin", "method Add", "function main"}
+	if got := names(ix.Symbols("main.go")); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Symbols = %v, want %v", got, want)
+	}
+	add := ix.Definitions("Add")
+	if len(add) != 1 || add[0].Path != "main.go" {
+		t.Fatalf("Definitions(Add) = %v", add)
+	}
+	if got := go_source[add[0].NameRange.StartByte:add[0].NameRange.EndByte]; got != "Add" {
+		t.Fatalf("name range covers %q", got)
+	}
+	if !strings.HasPrefix(go_source[add[0].Range.StartByte:], "func (p Point) Add") {
+		t.Fatalf("definition starts at %q", go_source[add[0].Range.StartByte:])
+	}
+
+	p, py := r.Open("lib.py", []byte("class A:\n    def f(self):\n        pass\n\ndef main():\n    pass\n"))
+	ix.Update("lib.py", py, p.Current())
+	want = []string{"class A", "method f", "function main"}
+	if got := names(ix.Symbols("lib.py")); !reflect.DeepEqual(got, want) {
+		t.Fatalf("python Symbols = %v, want %v", got, want)
+	}
+	if defs := ix.Definitions("main"); len(defs) != 2 || defs[0].Path != "lib.py" || defs[1].Path != "main.go" {
+		t.Fatalf("Definitions(main) = %v", defs)
+	}
+
+	ix.Remove("lib.py")
+	if defs := ix.Definitions("main"); len(defs) != 1 || ix.Definitions("A") != nil {
+		t.Fatalf("after Remove: %v %v", defs, ix.Definitions("A"))
+	}
+	if got := ix.Paths(); !reflect.DeepEqual(got, []string{"main.go"}) {
+		t.Fatalf("Paths = %v", got)
+	}
+}
+
+func TestSymbolsInjected(t *testing.T) {
+	r := DefaultRegistry()
+	ix := NewIndex(r)
+	d, md := r.Open("page.md", []byte(wiki_page))
+	ix.Update("page.md", md, d.Current())
+	want := []string{"heading Notes", "function main", "function f"}
+	if got := names(ix.Symbols("page.md")); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Symbols = %v, want %v", got, want)
+	}
+	if defs := ix.Definitions("f"); len(defs) != 1 || defs[0].Language != "python" {
+		t.Fatalf("Definitions(f) = %v", defs)
+	}
+}
+
+func TestSymbolsIncremental(t *testing.T) {
+	r := DefaultRegistry()
+	l := r.Lookup("go")
+	d := NewDocument(l.Grammar, []byte(strings.Repeat(go_source[len("package main\n"):], 20)))
+	d.Insert(0, []byte("package main\n"))
+	ix := NewIndex(r)
+	ix.Update("a.go", l, d.Current())
+
+	// one small edit only looks at the declaration it lands in
+	v, _ := d.Insert(strings.Index(go_source, "println"), []byte("x := 1; "))
+	ix.Update("a.go", l, v)
+	if q := ix.files["a.go"].queried; q > 2 {
+		t.Fatalf("queried %d top level nodes for one edit", q)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		size := d.Current().Text.Size()
+		off := rng.Intn(size)
+		if rng.Intn(2) == 0 {
+			v, _ = d.Delete(off, min(rng.Intn(8), size-off))
+		} else {
+			v, _ = d.Insert(off, []byte([]string{"func g() {}\n", "}", "type T int\n", "x"}[rng.Intn(4)]))
+		}
+		ix.Update("a.go", l, v)
+
+		fresh := NewIndex(r)
+		fresh.Update("a.go", l, &Version{Text: v.Text, Tree: v.Tree})
+		if got, want := ix.Symbols("a.go"), fresh.Symbols("a.go"); !reflect.DeepEqual(got, want) {
+			t.Fatalf("edit %d: incremental symbols\n%v\nwant\n%v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+/* The syntax layer keeps a tree-sitter parse tree next to every version of a
+   document's text.
+   tree-sitter reads its input through a callback, which we answer straight
+   out of the Trie[byte] of the version being parsed, so the text is never
+   flattened into one slice. Every insert or delete becomes the matching
+   tree.Edit on a copy of the previous tree, and the copy is handed back to the
+   parser so it can reuse whatever the edit did not touch. The previous tree
+   is left alone, so each version keeps a tree that matches its text.
+ */
+package web
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// how much text the parser gets per read callback. each call goes through
+// cgo and copies, so a leaf at a time would be far too little
+const read_chunk = 4096
+
+type Version struct {
+	Rev  int
+	Text *Trie[byte]
+	// Tree is nil when the document has no language. Trees are not safe to
+	// use from several goroutines, Copy one first.
+	Tree *sitter.Tree
+	// Edit is what turned the previous version into this one. It is nil for
+	// the first version and when the language changed, as the whole tree is
+	// new then.
+	Edit *sitter.EditInput
+}
+
+type Document struct {
+	mu       sync.Mutex
+	parser   *sitter.Parser
+	lang     *sitter.Language
+	versions []*Version // oldest first, Forget drops from the front
+}
+
+// NewDocument parses text with lang, which may be nil for plain text.
+func NewDocument(lang *sitter.Language, text []byte) *Document {
+	return new_document(lang, TrieFromSlice[byte](text))
+}
+
+// new_document makes a document of t, which must be a new trie that no one
+// else has
+func new_document(lang *sitter.Language, t *Trie[byte]) *Document {
+	t.id = 0
+	d := &Document{parser: sitter.NewParser(), lang: lang}
+	if lang != nil {
+		d.parser.SetLanguage(lang)
+	}
+	d.versions = []*Version{{Rev: 0, Text: t, Tree: d.parse(nil, t)}}
+	return d
+}
+
+// TrieInput feeds tree-sitter from t.
+func TrieInput(t *Trie[byte]) sitter.Input {
+	buf := make([]byte, read_chunk)
+	return sitter.Input{
+		Read: func(offset uint32, _ sitter.Point) []byte {
+			// the parser copies what we return before asking again
+			return buf[:t.ReadInto(int(offset), buf)]
+		},
+		Encoding: sitter.InputEncodingUTF8,
+	}
+}
+
+func (d *Document)parse(old *sitter.Tree, t *Trie[byte]) *sitter.Tree {
+	if d.lang == nil {
+		return nil
+	}
+	return d.parser.ParseInput(old, TrieInput(t))
+}
+
+// PointAt returns the row and byte column of off in t.
+func PointAt(t *Trie[byte], off int) sitter.Point {
+	p := sitter.Point{}
+	t.eachLeaf(0, func(start int, leaf []byte) bool {
+		if start >= off {
+			return false
+		}
+		if start+len(leaf) > off {
+			leaf = leaf[:off-start]
+		}
+		p = advance(p, leaf)
+		return true
+	})
+	return p
+}
+
+// advance moves p past text
+func advance(p sitter.Point, text []byte) sitter.Point {
+	if nl := bytes.Count(text, []byte("\n")); nl > 0 {
+		p.Row += uint32(nl)
+		p.Column = uint32(len(text) - bytes.LastIndexByte(text, '\n') - 1)
+	} else {
+		p.Column += uint32(len(text))
+	}
+	return p
+}
+
+// EditFor describes replacing n bytes at off in t with p the way tree.Edit
+// wants it.
+func EditFor(t *Trie[byte], off, n int, p []byte) sitter.EditInput {
+	start := PointAt(t, off)
+	old := make([]byte, n)
+	t.ReadInto(off, old)
+	return sitter.EditInput{
+		StartIndex:  uint32(off),
+		OldEndIndex: uint32(off+n),
+		NewEndIndex: uint32(off+len(p)),
+		StartPoint:  start,
+		OldEndPoint: advance(start, old),
+		NewEndPoint: advance(start, p),
+	}
+}
+
+// shift_point moves a point that is past the edit to where it is after it.
+// Points before the edit stay where they are, and a point inside it makes no
+// sense after it and is moved to its new end.
+func shift_point(p sitter.Point, e sitter.EditInput) sitter.Point {
+	if point_before(p, e.StartPoint) {
+		return p
+	}
+	if point_before(p, e.OldEndPoint) {
+		return e.NewEndPoint
+	}
+	if p.Row == e.OldEndPoint.Row {
+		return sitter.Point{Row: e.NewEndPoint.Row,
+			Column: p.Column - e.OldEndPoint.Column + e.NewEndPoint.Column}
+	}
+	p.Row = p.Row - e.OldEndPoint.Row + e.NewEndPoint.Row
+	return p
+}
+
+func point_before(a, b sitter.Point) bool {
+	return a.Row < b.Row || (a.Row == b.Row && a.Column < b.Column)
+}
+
+func shift_index(i uint32, e sitter.EditInput) uint32 {
+	if i < e.StartIndex {
+		return i
+	}
+	if i < e.OldEndIndex {
+		return e.NewEndIndex
+	}
+	return i - e.OldEndIndex + e.NewEndIndex
+}
+
+// shift_range moves r the way tree.Edit moves a node that e does not touch.
+func shift_range(r sitter.Range, e sitter.EditInput) sitter.Range {
+	if r.EndByte <= e.StartIndex {
+		return r
+	}
+	return sitter.Range{
+		StartPoint: shift_point(r.StartPoint, e),
+		EndPoint:   shift_point(r.EndPoint, e),
+		StartByte:  shift_index(r.StartByte, e),
+		EndByte:    shift_index(r.EndByte, e),
+	}
+}
+
+func children(n *sitter.Node) []*sitter.Node {
+	out := make([]*sitter.Node, n.ChildCount())
+	for i := range out {
+		out[i] = n.Child(i)
+	}
+	return out
+}
+
+type top_level_key struct {
+	typ        string
+	start, end uint32
+}
+
+// unchanged_tops is how the layers above the parser stay incremental. They
+// keep what they worked out per top level node of old, and after e they only
+// redo the nodes that are not in the map this returns. It applies e to a copy
+// of old, and maps every top level node that tree-sitter did not mark with
+// HasChanges, keyed by its type and where it is after e, to its index in old.
+// A node of the new tree with the same key is the same node.
+func unchanged_tops(old *sitter.Tree, e sitter.EditInput) map[top_level_key]int {
+	edited := old.Copy()
+	defer edited.Close()
+	edited.Edit(e)
+	out := map[top_level_key]int{}
+	for i, c := range children(edited.RootNode()) {
+		if !c.HasChanges() {
+			out[top_level_key{c.Type(), c.StartByte(), c.EndByte()}] = i
+		}
+	}
+	return out
+}
+
+func key_of(n *sitter.Node) top_level_key {
+	return top_level_key{n.Type(), n.StartByte(), n.EndByte()}
+}
+
+func (d *Document)current() *Version {
+	return d.versions[len(d.versions)-1]
+}
+
+// Current returns the latest version.
+func (d *Document)Current() *Version {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.current()
+}
+
+// Version returns the version with revision rev, or nil if it was forgotten
+// or has not happened yet.
+func (d *Document)Version(rev int) *Version {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	first := d.versions[0].Rev
+	if rev < first || rev > d.current().Rev {
+		return nil
+	}
+	return d.versions[rev-first]
+}
+
+// Texts returns the text of every version the document keeps, oldest first.
+func (d *Document)Texts() []*Trie[byte] {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]*Trie[byte], len(d.versions))
+	for i, v := range d.versions {
+		out[i] = v.Text
+	}
+	return out
+}
+
+func (d *Document)Language() *sitter.Language {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lang
+}
+
+// SetLanguage parses the current text again with lang, which may be nil for
+// plain text, as a new version.
+func (d *Document)SetLanguage(lang *sitter.Language) *Version {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lang = lang
+	if lang != nil {
+		d.parser.SetLanguage(lang)
+	}
+	cur := d.current()
+	v := &Version{Rev: cur.Rev+1, Text: cur.Text, Tree: d.parse(nil, cur.Text)}
+	d.versions = append(d.versions, v)
+	return v
+}
+
+// Replace replaces n bytes at off with p, and parses the result.
+func (d *Document)Replace(off, n int, p []byte) (*Version, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cur := d.current()
+	if off < 0 || n < 0 || off+n > cur.Text.Size() {
+		return nil, ErrRange
+	}
+
+	edit := EditFor(cur.Text, off, n, p)
+	text := cur.Text.Delete(0, off, n).Insert(0, off, p)
+	return d.next(cur, text, edit), nil
+}
+
+// next adds text, which edit made out of cur, as the next version, and
+// parses it
+func (d *Document)next(cur *Version, text *Trie[byte], edit sitter.EditInput) *Version {
+	var old *sitter.Tree
+	if cur.Tree != nil {
+		// Edit changes the tree in place, and cur keeps its own
+		old = cur.Tree.Copy()
+		old.Edit(edit)
+	}
+	v := &Version{Rev: cur.Rev+1, Text: text, Tree: d.parse(old, text), Edit: &edit}
+	d.versions = append(d.versions, v)
+	return v
+}
+
+func (d *Document)Insert(off int, p []byte) (*Version, error) {
+	return d.Replace(off, 0, p)
+}
+
+func (d *Document)Delete(off, n int) (*Version, error) {
+	return d.Replace(off, n, nil)
+}
+
+var ErrForgetCurrent = errors.New("web: can not forget the current version")
+
+// Forget drops every version before rev, along with its tree.
+func (d *Document)Forget(rev int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cur := d.current()
+	if rev > cur.Rev {
+		return ErrForgetCurrent
+	}
+	first := d.versions[0].Rev
+	if rev <= first {
+		return nil
+	}
+	d.versions = append([]*Version(nil), d.versions[rev-first:]...)
+	return nil
+}
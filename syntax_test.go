@@ -0,0 +1,132 @@
+package web
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/javascript"
+)
+
+const js_source = `function hello() { console.log('hello') }; function goodbye(){}
+// a second line
+function third(a, b) {
+	return a + b
+}
+`
+
+func TestPointAt(t *testing.T) {
+	text := []byte(js_source + js_source + js_source)
+	a := TrieFromSlice[byte](text)
+	row, col := uint32(0), uint32(0)
+	for i := 0; i <= len(text); i++ {
+		p := PointAt(a, i)
+		if p.Row != row || p.Column != col {
+			t.Fatalf("PointAt(%d) = %v, want %d:%d", i, p, row, col)
+		}
+		if i < len(text) && text[i] == '\n' {
+			row, col = row+1, 0
+		} else {
+			col++
+		}
+	}
+}
+
+// the same edit misc/tree.go works out by hand
+func TestEditFor(t *testing.T) {
+	a := TrieFromSlice[byte]([]byte("function hello() { console.log('hello') }; function goodbye(){}"))
+	e := EditFor(a, 62, 1, []byte(" console.log('goodbye') }"))
+	want := sitter.EditInput{
+		StartIndex:  62,
+		OldEndIndex: 63,
+		NewEndIndex: 87,
+		StartPoint:  sitter.Point{Row: 0, Column: 62},
+		OldEndPoint: sitter.Point{Row: 0, Column: 63},
+		NewEndPoint: sitter.Point{Row: 0, Column: 87},
+	}
+	if e != want {
+		t.Fatalf("EditFor = %+v, want %+v", e, want)
+	}
+
+	b := TrieFromSlice[byte]([]byte("ab\ncd\nef"))
+	e = EditFor(b, 1, 4, []byte("X\nY\nZZ"))
+	if e.OldEndPoint != (sitter.Point{Row: 1, Column: 2}) ||
+		e.NewEndPoint != (sitter.Point{Row: 2, Column: 2}) {
+		t.Fatalf("EditFor across lines = %+v", e)
+	}
+}
+
+// parsing incrementally has to end up with the same tree as parsing the
+// text from scratch
+func TestDocumentIncremental(t *testing.T) {
+	lang := javascript.GetLanguage()
+	d := NewDocument(lang, []byte(js_source))
+	r := rand.New(rand.NewSource(3))
+	snippets := []string{"x", "\n", "}", "{", "function f() {}\n", "a + ", "(", ";"}
+	for i := 0; i < 200; i++ {
+		cur := d.Current()
+		size := cur.Text.Size()
+		off := r.Intn(size+1)
+		var err error
+		if size > 0 && r.Intn(3) == 0 {
+			_, err = d.Delete(off, min(r.Intn(8), size-off))
+		} else {
+			_, err = d.Insert(off, []byte(snippets[r.Intn(len(snippets))]))
+		}
+		if err != nil {
+			t.Fatalf("edit %d: %v", i, err)
+		}
+
+		v := d.Current()
+		text := trieBytes(v.Text)
+		fresh := sitter.Parse(text, lang)
+		if got, want := v.Tree.RootNode().String(), fresh.String(); got != want {
+			t.Fatalf("edit %d: incremental tree\n%s\n!= fresh tree\n%s\nfor %q",
+				i, got, want, text)
+		}
+	}
+}
+
+func TestDocumentVersions(t *testing.T) {
+	d := NewDocument(javascript.GetLanguage(), []byte(js_source))
+	v1, _ := d.Insert(0, []byte("let x = 1;\n"))
+	v2, _ := d.Delete(0, 4)
+	if v1.Rev != 1 || v2.Rev != 2 {
+		t.Fatalf("revisions %d %d", v1.Rev, v2.Rev)
+	}
+	for rev, want := range []int{len(js_source), len(js_source)+11, len(js_source)+7} {
+		v := d.Version(rev)
+		if v.Text.Size() != want || int(v.Tree.RootNode().EndByte()) != want {
+			t.Fatalf("version %d: text %d tree %d, want %d",
+				rev, v.Text.Size(), v.Tree.RootNode().EndByte(), want)
+		}
+	}
+	if v2.Edit.StartIndex != 0 || v2.Edit.OldEndIndex != 4 || v2.Edit.NewEndIndex != 0 {
+		t.Fatalf("v2.Edit = %+v", v2.Edit)
+	}
+
+	if err := d.Forget(2); err != nil {
+		t.Fatalf("Forget(2) = %v", err)
+	}
+	if d.Version(1) != nil || d.Version(2) != v2 {
+		t.Fatalf("Forget(2) kept the wrong versions")
+	}
+	if err := d.Forget(3); err != ErrForgetCurrent {
+		t.Fatalf("Forget(3) = %v", err)
+	}
+	if _, err := d.Delete(0, 1000); err != ErrRange {
+		t.Fatalf("Delete past the end = %v", err)
+	}
+}
+
+func TestDocumentPlainText(t *testing.T) {
+	d := NewDocument(nil, []byte("just text"))
+	v, err := d.Insert(4, []byte(" some"))
+	if err != nil || v.Tree != nil {
+		t.Fatalf("Insert = %v, tree %v", err, v.Tree)
+	}
+	if !bytes.Equal(trieBytes(v.Text), []byte("just some text")) {
+		t.Fatalf("text = %q", trieBytes(v.Text))
+	}
+}